@@ -5,8 +5,14 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
+	"os"
+	"os/signal"
 	rpe "rpe/internal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -21,8 +27,120 @@ func main() {
 	log.Println("DNS Suffix: ", flags.DNSSuffix, "RPE Port: ", flags.Port)
 	log.Println("Remote Provisioning Extension (RPE) starting ...")
 
-	error := rpe.SendAck(flags.DNSSuffix)
-	if error != nil {
-		log.Println("Error sending Ack packet: ", error)
+	var reservations *rpe.Reservations
+	if flags.ReservationsFile != "" {
+		reservations, err = rpe.LoadReservations(flags.ReservationsFile)
+		if err != nil {
+			log.Fatalln("Error loading reservations: ", err)
+		}
+		log.Println("Loaded ", reservations.Len(), " reservations from ", flags.ReservationsFile)
 	}
+
+	// run until SIGINT/SIGTERM or until either service fails
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cfg := rpe.NewConfig(flags.DNSSuffix)
+	cfg.Reservations = reservations
+	if flags.CertFile != "" {
+		cfg.ProvisioningCert, err = rpe.LoadProvisioningCert(flags.CertFile, flags.CertPassword)
+		if err != nil {
+			log.Fatalln("Error loading provisioning certificate: ", err)
+		}
+		log.Println("Checking DNS suffixes against ", cfg.ProvisioningCert.Subject)
+	}
+	cfg.StrictSuffix = flags.StrictSuffix
+	if flags.RPSURL != "" {
+		cfg.RPS, err = rpe.NewRPSClient(flags.RPSURL, flags.MPSURL, flags.RPSToken)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		cfg.RPS.CertPassword = flags.CertPassword
+	}
+	cfg.Interface = flags.Interface
+	// the lists were checked by ParseFlags
+	cfg.DNSServers, _ = rpe.ParseIPList(flags.DNSServers)
+	cfg.NTPServers, _ = rpe.ParseIPList(flags.NTPServers)
+	cfg.DomainSearch, _ = rpe.ParseDomainList(flags.DomainSearch)
+	if routers, _ := rpe.ParseIPList(flags.Router); len(routers) > 0 {
+		cfg.Router = routers[0]
+	}
+	if flags.ConfigFile != "" {
+		fileConfig, err := rpe.LoadConfigFile(flags.ConfigFile)
+		if err != nil {
+			log.Fatalln("Error loading configuration: ", err)
+		}
+		cfg.Scopes = fileConfig.Scopes
+		cfg.RelayRules = fileConfig.RelayRules
+		cfg.Classes = fileConfig.Classes
+		cfg.ServeUnclassified = fileConfig.ServeUnclassified
+		log.Println("Loaded ", len(cfg.Scopes), " scopes, ", len(cfg.RelayRules), " relay rules and ", len(cfg.Classes), " client classes from ", flags.ConfigFile)
+	}
+	if flags.AMTOnly && len(cfg.Classes) == 0 {
+		cfg.Classes = rpe.DefaultClientClasses()
+	}
+	if flags.PoolRange != "" {
+		cfg.Pool, err = rpe.ParsePool(flags.PoolRange, flags.PoolExclude)
+		if err != nil {
+			log.Fatalln("Error parsing address pool: ", err)
+		}
+		if flags.Probe {
+			cfg.Pool.Prober = rpe.ICMPProber{Timeout: 500 * time.Millisecond}
+		}
+	}
+	if flags.LeaseFile != "" {
+		cfg.Leases, err = rpe.NewFileLeaseStore(flags.LeaseFile)
+		if err != nil {
+			log.Fatalln("Error loading leases: ", err)
+		}
+	}
+	cfg.LimitedBroadcast = flags.LimitedBroadcast
+	cfg.Proxy = flags.Proxy
+	cfg.RawSocket = flags.RawSocket
+	server, err := rpe.NewServer(cfg)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	// the server hooks the suffix check into reloads, start them after it
+	if reservations != nil {
+		go reservations.Watch(ctx, 10*time.Second)
+	}
+	if cfg.RPS != nil {
+		// RPS being down at startup is not fatal, the next sync may succeed
+		if err := cfg.RPS.Sync(ctx); err != nil {
+			log.Println("Error syncing with RPS: ", err)
+		} else {
+			log.Println("Loaded ", len(cfg.RPS.Domains()), " domains from RPS at ", flags.RPSURL, " and ", cfg.RPS.Status().Devices, " devices from MPS at ", flags.MPSURL)
+		}
+		go cfg.RPS.Watch(ctx, time.Minute)
+	}
+
+	api := rpe.NewAPI(server, flags.Port)
+	api.Host = flags.APIHost
+	api.Token = flags.APIToken
+	if ip := net.ParseIP(api.Host); api.Token == "" && (ip == nil || !ip.IsLoopback()) {
+		log.Println("Warning: control API on ", api.Host, " is open to anyone who can reach it, set API_TOKEN")
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- server.Run(ctx)
+	}()
+	go func() {
+		errs <- api.ListenAndServe(ctx)
+	}()
+
+	exitCode := 0
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			log.Println("Error running RPE: ", err)
+			exitCode = 1
+		}
+		cancel()
+	}
+	log.Println("Remote Provisioning Extension (RPE) stopped")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,209 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API serves the HTTP control interface of RPE.  It can send DHCPACKs and
+// exposes leases, so it listens on the loopback interface unless Host says
+// otherwise, and requires Token when one is set.
+type API struct {
+	Host  string // address the API listens on
+	Token string // bearer token required by all but the health check, if set
+
+	server  *Server
+	port    int
+	sendAck func(domainName string) error
+}
+
+type configResponse struct {
+	DNSSuffix        string   `json:"dnsSuffix"`
+	Port             int      `json:"port"`
+	ReservationsFile string   `json:"reservationsFile,omitempty"`
+	Router           net.IP   `json:"router,omitempty"`
+	DNSServers       []net.IP `json:"dnsServers,omitempty"`
+	NTPServers       []net.IP `json:"ntpServers,omitempty"`
+	DomainSearch     []string `json:"domainSearch,omitempty"`
+	Proxy            bool     `json:"proxy,omitempty"`
+}
+
+type ackRequest struct {
+	DNSSuffix string `json:"dnsSuffix"`
+}
+
+func NewAPI(server *Server, port int) *API {
+	return &API{Host: "127.0.0.1", server: server, port: port, sendAck: server.SendAck}
+}
+
+// Handler returns the routes of the control API
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/health", a.health)
+	mux.HandleFunc("/api/v1/status", a.authorized(a.status))
+	mux.HandleFunc("/api/v1/config", a.authorized(a.config))
+	mux.HandleFunc("/api/v1/ack", a.authorized(a.ack))
+	mux.HandleFunc("/api/v1/leases", a.authorized(a.leases))
+	mux.HandleFunc("/api/v1/snoop", a.authorized(a.snoop))
+	mux.HandleFunc("/api/v1/rps", a.authorized(a.rps))
+	return mux
+}
+
+// authorized rejects requests without the bearer token when one is set
+func (a *API) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// ListenAndServe serves the control API on the configured host and port
+// until ctx is cancelled, then shuts the server down gracefully.
+func (a *API) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    net.JoinHostPort(a.Host, strconv.Itoa(a.port)),
+		Handler: a.Handler(),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if e := <-errs; e != nil && !errors.Is(e, http.ErrServerClosed) && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (a *API) health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Status())
+}
+
+func (a *API) config(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := a.server.Config()
+	writeJSON(w, http.StatusOK, configResponse{
+		DNSSuffix:        cfg.DNSSuffix,
+		Port:             a.port,
+		ReservationsFile: cfg.Reservations.Path(),
+		Router:           cfg.Router,
+		DNSServers:       cfg.DNSServers,
+		NTPServers:       cfg.NTPServers,
+		DomainSearch:     cfg.DomainSearch,
+		Proxy:            cfg.Proxy,
+	})
+}
+
+func (a *API) leases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Leases())
+}
+
+// snoop lists the clients followed in proxy mode
+func (a *API) snoop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Snooped())
+}
+
+// ack sends an unsolicited DHCPACK, optionally with a DNS suffix other than
+// the configured one.
+func (a *API) ack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := ackRequest{DNSSuffix: a.server.Config().DNSSuffix}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.DNSSuffix == "" {
+		http.Error(w, "dnsSuffix cannot be empty", http.StatusBadRequest)
+		return
+	}
+	suffix, err := NormalizeDomain(req.DNSSuffix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.DNSSuffix = suffix
+	if err := a.sendAck(req.DNSSuffix); err != nil {
+		log.Println("Error sending Ack packet: ", err)
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSuffixMismatch) {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent", "dnsSuffix": req.DNSSuffix})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing response: ", err)
+	}
+}
+
+// rps reports what was last fetched from RPS
+func (a *API) rps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Config().RPS.Status())
+}
@@ -0,0 +1,218 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPI(t *testing.T) (*API, *string) {
+	sent := new(string)
+	api := NewAPI(newTestServer(t), 3050)
+	api.sendAck = func(domainName string) error {
+		*sent = domainName
+		return nil
+	}
+	return api, sent
+}
+
+func TestAPIHealth(t *testing.T) {
+	api, _ := newTestAPI(t)
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestAPIStatus(t *testing.T) {
+	api, _ := newTestAPI(t)
+	w := httptest.NewRecorder()
+	api.server.status.countRequest()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rcvd Status
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rcvd))
+	assert.NotZero(t, rcvd.Requests)
+}
+
+func TestAPIConfig(t *testing.T) {
+	api, _ := newTestAPI(t)
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/config", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"dnsSuffix":"test.com","port":3050}`, w.Body.String())
+
+	api.server.cfg.Router = net.IPv4(10, 0, 0, 1)
+	api.server.cfg.DNSServers = []net.IP{net.IPv4(10, 0, 0, 53)}
+	api.server.cfg.DomainSearch = []string{"test.com", "example.com"}
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/config", nil))
+	assert.JSONEq(t, `{"dnsSuffix":"test.com","port":3050,"router":"10.0.0.1","dnsServers":["10.0.0.53"],"domainSearch":["test.com","example.com"]}`, w.Body.String())
+}
+
+func TestAPILeases(t *testing.T) {
+	api, _ := newTestAPI(t)
+	api.server.cfg.Leases.Put(Lease{MAC: "00:11:22:33:44:55", IP: net.IPv4(10, 0, 0, 1).To4(), Expiry: time.Unix(0, 0).UTC()})
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/leases", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"mac":"00:11:22:33:44:55","ip":"10.0.0.1","expiry":"1970-01-01T00:00:00Z"}]`, w.Body.String())
+}
+
+func TestAPISnoop(t *testing.T) {
+	api, _ := newTestAPI(t)
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/snoop", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
+
+func TestAPIAck(t *testing.T) {
+	api, sent := newTestAPI(t)
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test.com", *sent)
+}
+
+func TestAPIAckWithSuffix(t *testing.T) {
+	api, sent := newTestAPI(t)
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"dnsSuffix":"other.com"}`)
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", body))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "other.com", *sent)
+}
+
+func TestAPIAckNormalizesSuffix(t *testing.T) {
+	api, sent := newTestAPI(t)
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"dnsSuffix":"bücher.example."}`)
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", body))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "xn--bcher-kva.example", *sent)
+}
+
+func TestAPIAckErrors(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", strings.NewReader(`{"dnsSuffix":"my site.com"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/ack", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	api.sendAck = func(string) error { return errors.New("no network") }
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	api.sendAck = func(string) error { return fmt.Errorf("%w: test.com", ErrSuffixMismatch) }
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	api, _ := newTestAPI(t)
+	for _, path := range []string{"/api/v1/health", "/api/v1/status", "/api/v1/config", "/api/v1/leases", "/api/v1/snoop"} {
+		w := httptest.NewRecorder()
+		api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	api, _ := newTestAPI(t)
+	api.Token = "secret"
+
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ack", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/leases", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	api.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/leases", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	api.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the health check stays open to probes
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNewAPILoopback(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", NewAPI(newTestServer(t), 3050).Host)
+}
+
+func TestAPIListenAndServeShutdown(t *testing.T) {
+	api := NewAPI(newTestServer(t), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() { errs <- api.ListenAndServe(ctx) }()
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("control API did not shut down")
+	}
+}
+
+func TestAPIRPS(t *testing.T) {
+	api, _ := newTestAPI(t)
+	w := httptest.NewRecorder()
+
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/rps", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"url":"","mpsUrl":"","synced":"0001-01-01T00:00:00Z","domains":[],"devices":0}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/rps", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,133 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ErrSuffixMismatch is returned for DNS suffixes the provisioning
+// certificate does not cover
+var ErrSuffixMismatch = errors.New("dns suffix does not match the provisioning certificate")
+
+// ProvisioningCert holds what RPE needs of the certificate AMT is activated
+// with in admin control mode: the domains it was issued for.
+type ProvisioningCert struct {
+	Subject  string
+	Domains  []string // common name and DNS names, lower case without wildcard
+	NotAfter time.Time
+}
+
+// LoadProvisioningCert reads the provisioning certificate at path, a PEM
+// file or a PKCS#12 (PFX) bundle protected by password.
+func LoadProvisioningCert(path string, password string) (*ProvisioningCert, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseProvisioningCert(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cert, nil
+}
+
+// ParseProvisioningCert reads a provisioning certificate in PEM, PKCS#12 or
+// DER form.  Of a chain the leaf certificate is used.
+func ParseProvisioningCert(data []byte, password string) (*ProvisioningCert, error) {
+	var blocks []*pem.Block
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		if certs, err := x509.ParseCertificates(data); err == nil {
+			return newProvisioningCert(certs)
+		}
+		var err error
+		blocks, err = pkcs12.ToPEM(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("not a PEM, DER or PKCS#12 certificate: %w", err)
+		}
+	}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return newProvisioningCert(certs)
+}
+
+func newProvisioningCert(certs []*x509.Certificate) (*ProvisioningCert, error) {
+	var leaf *x509.Certificate
+	for _, cert := range certs {
+		if !cert.IsCA {
+			leaf = cert
+			break
+		}
+	}
+	if leaf == nil {
+		return nil, errors.New("no end entity certificate found")
+	}
+	pc := &ProvisioningCert{Subject: leaf.Subject.String(), NotAfter: leaf.NotAfter}
+	for _, name := range append([]string{leaf.Subject.CommonName}, leaf.DNSNames...) {
+		name = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(name), "*."), ".")
+		if name != "" {
+			pc.Domains = append(pc.Domains, name)
+		}
+	}
+	if len(pc.Domains) == 0 {
+		return nil, errors.New("certificate names no domain")
+	}
+	return pc, nil
+}
+
+// Matches reports whether AMT accepts suffix with this certificate: one of
+// the certificate domains must be the suffix or end in it, the way AMT
+// compares the domain of the certificate with the DHCP suffix.
+func (c *ProvisioningCert) Matches(suffix string) bool {
+	suffix = strings.TrimSuffix(strings.ToLower(suffix), ".")
+	if suffix == "" {
+		return false
+	}
+	for _, domain := range c.Domains {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns ErrSuffixMismatch, with the domains expected, when the
+// certificate does not cover suffix.
+func (c *ProvisioningCert) Check(suffix string) error {
+	if c.Matches(suffix) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is not within %s", ErrSuffixMismatch, suffix, strings.Join(c.Domains, ", "))
+}
+
+// Expired reports whether the certificate is no longer valid at now
+func (c *ProvisioningCert) Expired(now time.Time) bool {
+	return now.After(c.NotAfter)
+}
@@ -0,0 +1,228 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCertChain returns a PEM chain of a CA and a provisioning
+// certificate it issued for cn and dnsNames, valid until notAfter
+func newTestCertChain(t *testing.T, cn string, dnsNames []string, notAfter time.Time) []byte {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	leaf := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		// the AMT provisioning OID
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{{2, 16, 840, 1, 113741, 1, 2, 3}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+}
+
+func newTestProvisioningCert(t *testing.T) *ProvisioningCert {
+	cert, err := ParseProvisioningCert(newTestCertChain(t, "example.com", []string{"*.lab.example.org"}, time.Now().Add(time.Hour)), "")
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestParseProvisioningCertPEM(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	cert, err := ParseProvisioningCert(newTestCertChain(t, "Example.com.", []string{"*.lab.example.org"}, notAfter), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "lab.example.org"}, cert.Domains)
+	assert.Equal(t, "CN=Example.com.,O=Example", cert.Subject)
+	assert.True(t, notAfter.Equal(cert.NotAfter))
+}
+
+func TestParseProvisioningCertDER(t *testing.T) {
+	block, _ := pem.Decode(newTestCertChain(t, "example.com", nil, time.Now().Add(time.Hour)))
+
+	cert, err := ParseProvisioningCert(block.Bytes, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, cert.Domains)
+}
+
+func TestParseProvisioningCertInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not a certificate"),
+		// a PEM without certificates
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{0}}),
+	} {
+		_, err := ParseProvisioningCert(data, "")
+		assert.Error(t, err, string(data))
+	}
+
+	// a chain of CA certificates only
+	chain := newTestCertChain(t, "example.com", nil, time.Now().Add(time.Hour))
+	_, rest := pem.Decode(chain)
+	_, err := ParseProvisioningCert(rest, "")
+	assert.Error(t, err)
+}
+
+func TestLoadProvisioningCert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "provisioning.pem")
+	assert.NoError(t, os.WriteFile(path, newTestCertChain(t, "example.com", nil, time.Now().Add(time.Hour)), 0600))
+
+	cert, err := LoadProvisioningCert(path, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, cert.Domains)
+	_, err = LoadProvisioningCert(filepath.Join(t.TempDir(), "missing.pfx"), "")
+	assert.Error(t, err)
+}
+
+func TestProvisioningCertMatches(t *testing.T) {
+	cert := newTestProvisioningCert(t)
+
+	for suffix, matches := range map[string]bool{
+		"example.com":          true,
+		"EXAMPLE.COM.":         true,
+		"lab.example.org":      true,
+		"example.org":          true,
+		"site1.example.com":    false,
+		"a.lab.example.org":    false,
+		"ample.com":            false,
+		"badexample.com":       false,
+		"example.com.evil.net": false,
+		"":                     false,
+	} {
+		assert.Equal(t, matches, cert.Matches(suffix), suffix)
+	}
+	assert.NoError(t, cert.Check("example.com"))
+	assert.ErrorIs(t, cert.Check("site1.example.com"), ErrSuffixMismatch)
+
+	cert, err := ParseProvisioningCert(newTestCertChain(t, "rps.example.com", nil, time.Now().Add(time.Hour)), "")
+	assert.NoError(t, err)
+	assert.True(t, cert.Matches("example.com"))
+	assert.True(t, cert.Matches("rps.example.com"))
+	assert.False(t, cert.Matches("x.rps.example.com"))
+}
+
+func TestProvisioningCertExpired(t *testing.T) {
+	cert := newTestProvisioningCert(t)
+
+	assert.False(t, cert.Expired(time.Now()))
+	assert.True(t, cert.Expired(time.Now().Add(2*time.Hour)))
+}
+
+func TestNewServerProvisioningCert(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.ProvisioningCert = newTestProvisioningCert(t)
+	cfg.Scopes = newTestScopes()
+
+	// a mismatch is only logged unless strict
+	_, err := NewServer(cfg)
+	assert.NoError(t, err)
+
+	cfg.StrictSuffix = true
+	_, err = NewServer(cfg)
+	assert.ErrorIs(t, err, ErrSuffixMismatch)
+
+	// the scope suffixes are checked as well
+	cfg.DNSSuffix = "example.com"
+	_, err = NewServer(cfg)
+	assert.ErrorIs(t, err, ErrSuffixMismatch)
+
+	for _, scope := range cfg.Scopes {
+		scope.DomainName = "lab.example.org"
+	}
+	_, err = NewServer(cfg)
+	assert.NoError(t, err)
+}
+
+func TestSendAckCheckSuffix(t *testing.T) {
+	cfg := NewConfig("example.com")
+	cfg.ProvisioningCert = newTestProvisioningCert(t)
+	cfg.StrictSuffix = true
+	s, err := NewServer(cfg)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, s.SendAck("example.net"), ErrSuffixMismatch)
+}
+
+func TestReservationsReloadCheckDomain(t *testing.T) {
+	path := writeReservations(t, testReservations)
+	cfg := NewConfig("example.com")
+	cfg.ProvisioningCert, _ = ParseProvisioningCert(newTestCertChain(t, "amt.site1.example.com", []string{"amt.site2.example.com"}, time.Now().Add(time.Hour)), "")
+	cfg.StrictSuffix = true
+	cfg.Reservations, _ = LoadReservations(path)
+	assert.Equal(t, []string{"site1.example.com", "site2.example.com"}, cfg.Reservations.DomainNames())
+	_, err := NewServer(cfg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("reservations: [{mac: 00:11:22:33:44:66, domainName: example.net}]"), 0600))
+	_, err = cfg.Reservations.Reload()
+
+	assert.ErrorIs(t, err, ErrSuffixMismatch)
+	assert.Equal(t, 2, cfg.Reservations.Len())
+}
+
+func TestRPSClientSyncCheckDomain(t *testing.T) {
+	// lab has a certificate of its own, site1 one that cannot be decoded and
+	// other is checked against the configured one
+	chain := newTestCertChain(t, "lab.example.com", nil, time.Now().Add(time.Hour))
+	block, _ := pem.Decode(chain)
+	rps := newTestRPS(t, `[
+		{"profileName": "site1", "domainSuffix": "site1.example.com", "provisioningCert": "MIIK"},
+		{"profileName": "lab", "domainSuffix": "lab.example.com", "provisioningCert": "`+base64.StdEncoding.EncodeToString(block.Bytes)+`"},
+		{"profileName": "other", "domainSuffix": "example.net"}
+	]`, "[]")
+	cfg := NewConfig("site1.example.com")
+	cfg.ProvisioningCert, _ = ParseProvisioningCert(newTestCertChain(t, "site1.example.com", nil, time.Now().Add(time.Hour)), "")
+	cfg.StrictSuffix = true
+	cfg.RPS = newTestRPSClientOf(t, rps)
+	_, err := NewServer(cfg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cfg.RPS.Sync(context.Background()))
+
+	var names []string
+	for _, domain := range cfg.RPS.Domains() {
+		names = append(names, domain.ProfileName)
+	}
+	assert.Equal(t, []string{"lab"}, names)
+}
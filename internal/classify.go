@@ -0,0 +1,169 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// amtVendorClass prefixes the vendor class identifier (option 60) Intel AMT
+// sends in its requests
+const amtVendorClass = "iAMT"
+
+// ClientClass recognises a kind of client from its requests.  A request
+// belongs to the class when any one of the criteria matches it.
+type ClientClass struct {
+	Name          string
+	VendorClasses []string // option 60 prefixes
+	ClientIDs     [][]byte // option 61 prefixes
+	UUIDs         []string // option 97 machine UUIDs, lower case
+	OUIs          [][]byte // first three bytes of chaddr
+	Deny          bool     // members are never answered
+	DomainName    string   // option 15 override, empty to keep the server suffix
+}
+
+type classConfig struct {
+	Name        string   `yaml:"name"`
+	VendorClass []string `yaml:"vendorClass"`
+	ClientID    []string `yaml:"clientId"`
+	UUID        []string `yaml:"uuid"`
+	OUI         []string `yaml:"oui"`
+	Action      string   `yaml:"action"`
+	DomainName  string   `yaml:"domainName"`
+}
+
+// DefaultClientClasses returns the built-in Intel AMT class, recognised by
+// the vendor class it sends.  Proxy mode uses it when no classes are
+// configured.
+func DefaultClientClasses() []ClientClass {
+	return []ClientClass{{Name: "amt", VendorClasses: []string{amtVendorClass}}}
+}
+
+func (cfg classConfig) class() (ClientClass, error) {
+	class := ClientClass{Name: cfg.Name, VendorClasses: cfg.VendorClass}
+	if class.Name == "" {
+		return class, errors.New("name is required")
+	}
+	for _, vendorClass := range cfg.VendorClass {
+		if vendorClass == "" {
+			return class, errors.New("empty vendor class")
+		}
+	}
+	for _, s := range cfg.ClientID {
+		id, err := parseRelayID(s)
+		if err != nil || id == nil {
+			return class, fmt.Errorf("invalid client id %q", s)
+		}
+		class.ClientIDs = append(class.ClientIDs, id)
+	}
+	for _, uuid := range cfg.UUID {
+		if !validUUID(uuid) {
+			return class, fmt.Errorf("invalid uuid %q", uuid)
+		}
+		class.UUIDs = append(class.UUIDs, strings.ToLower(uuid))
+	}
+	for _, s := range cfg.OUI {
+		oui, err := parseOUI(s)
+		if err != nil {
+			return class, err
+		}
+		class.OUIs = append(class.OUIs, oui)
+	}
+	if len(class.VendorClasses)+len(class.ClientIDs)+len(class.UUIDs)+len(class.OUIs) == 0 {
+		return class, errors.New("at least one of vendorClass, clientId, uuid or oui is required")
+	}
+	switch cfg.Action {
+	case "", "allow":
+	case "deny":
+		class.Deny = true
+	default:
+		return class, fmt.Errorf("invalid action %q", cfg.Action)
+	}
+	if cfg.DomainName != "" {
+		domainName, err := NormalizeDomain(cfg.DomainName)
+		if err != nil {
+			return class, err
+		}
+		class.DomainName = domainName
+	}
+	return class, nil
+}
+
+// parseOUI reads the first three bytes of a MAC address, written with or
+// without ':', '-' or '.' separators
+func parseOUI(s string) ([]byte, error) {
+	digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(s)
+	oui, err := hex.DecodeString(digits)
+	if err != nil || len(oui) != 3 {
+		return nil, fmt.Errorf("invalid oui %q", s)
+	}
+	return oui, nil
+}
+
+func (class ClientClass) matches(req Packet, options Options) bool {
+	if vendorClass, ok := options[OptionVendorClassIdentifier]; ok {
+		for _, prefix := range class.VendorClasses {
+			if strings.HasPrefix(string(vendorClass), prefix) {
+				return true
+			}
+		}
+	}
+	if clientID, ok := options[OptionCLientIdentifier]; ok {
+		for _, prefix := range class.ClientIDs {
+			if bytes.HasPrefix(clientID, prefix) {
+				return true
+			}
+		}
+	}
+	if uuid := clientUUID(options); uuid != "" {
+		for _, id := range class.UUIDs {
+			if id == uuid {
+				return true
+			}
+		}
+	}
+	if mac := req.CHAddr(); len(mac) >= 3 {
+		for _, oui := range class.OUIs {
+			if bytes.Equal(mac[:3], oui) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classify returns the first class the request belongs to
+func classify(classes []ClientClass, req Packet, options Options) (ClientClass, bool) {
+	for _, class := range classes {
+		if class.matches(req, options) {
+			return class, true
+		}
+	}
+	return ClientClass{}, false
+}
+
+// findClass returns the class called name
+func findClass(classes []ClientClass, name string) (ClientClass, bool) {
+	for _, class := range classes {
+		if class.Name == name {
+			return class, true
+		}
+	}
+	return ClientClass{}, false
+}
+
+// options returns the reply options overridden by the class.  The domain
+// name was checked when the class was loaded.
+func (class ClientClass) options() []Option {
+	if class.DomainName == "" {
+		return nil
+	}
+	opt, _ := OptionDomain(OptionDomainName, class.DomainName)
+	return []Option{opt}
+}
@@ -0,0 +1,96 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testClasses = `
+classes:
+  - name: lab
+    uuid: [8E2A5D1C-0B3F-4C6A-9D7E-112233445566]
+    domainName: lab.example.com
+  - name: amt
+    vendorClass: [iAMT]
+    clientId: [0x01a4bb6d]
+    oui: ["00:1B:21", a4-ae-11]
+  - name: printers
+    vendorClass: [Hewlett-Packard]
+    action: deny
+`
+
+func newTestClasses() []ClientClass {
+	cfg, _ := parseConfigFile([]byte(testClasses))
+	return cfg.Classes
+}
+
+// newTestMachineID returns option 97 for the UUID of the lab class, whose
+// first three fields are sent little endian
+func newTestMachineID() []byte {
+	return []byte{0, 0x1c, 0x5d, 0x2a, 0x8e, 0x3f, 0x0b, 0x6a, 0x4c, 0x9d, 0x7e, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+}
+
+func TestClassify(t *testing.T) {
+	classes := newTestClasses()
+	for name, tc := range map[string]struct {
+		mac    string
+		option OptionCode
+		value  []byte
+		class  string
+	}{
+		"vendor class":   {"00:11:22:33:44:55", OptionVendorClassIdentifier, []byte("iAMT:15.0"), "amt"},
+		"client id":      {"00:11:22:33:44:55", OptionCLientIdentifier, []byte{1, 0xa4, 0xbb, 0x6d, 0x01, 0x02, 0x03}, "amt"},
+		"oui":            {"00:1b:21:33:44:55", OptionHostName, []byte("nuc"), "amt"},
+		"second oui":     {"a4:ae:11:33:44:55", OptionHostName, []byte("nuc"), "amt"},
+		"uuid":           {"00:11:22:33:44:55", OptionClientMachineID, newTestMachineID(), "lab"},
+		"denied":         {"00:11:22:33:44:55", OptionVendorClassIdentifier, []byte("Hewlett-Packard JetDirect"), "printers"},
+		"other vendor":   {"00:11:22:33:44:55", OptionVendorClassIdentifier, []byte("MSFT 5.0"), ""},
+		"other clientid": {"00:11:22:33:44:55", OptionCLientIdentifier, []byte{1, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, ""},
+	} {
+		req := newTestRequest(dhcpDiscover)
+		mac, _ := net.ParseMAC(tc.mac)
+		req.SetCHAddr(mac)
+		req.AddOption(tc.option, tc.value)
+		options, _ := req.Options()
+
+		class, ok := classify(classes, req, options)
+
+		assert.Equal(t, tc.class != "", ok, name)
+		assert.Equal(t, tc.class, class.Name, name)
+	}
+}
+
+func TestClientClassOptions(t *testing.T) {
+	classes := newTestClasses()
+
+	assert.Equal(t, []Option{{Code: OptionDomainName, Value: []byte("lab.example.com")}}, classes[0].options())
+	assert.Nil(t, classes[1].options())
+	assert.True(t, classes[2].Deny)
+}
+
+func TestFindClass(t *testing.T) {
+	class, ok := findClass(newTestClasses(), "printers")
+	assert.True(t, ok)
+	assert.Equal(t, "printers", class.Name)
+
+	_, ok = findClass(newTestClasses(), "")
+	assert.False(t, ok)
+}
+
+func TestParseOUI(t *testing.T) {
+	for _, s := range []string{"00:1b:21", "00-1B-21", "001b.21", "001b21"} {
+		oui, err := parseOUI(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, []byte{0x00, 0x1b, 0x21}, oui, s)
+	}
+	for _, s := range []string{"", "00:1b", "00:1b:21:33", "zz:1b:21"} {
+		_, err := parseOUI(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,76 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the part of the configuration read from the YAML or JSON
+// file given with -c
+type FileConfig struct {
+	Scopes     []*Scope
+	RelayRules []RelayRule
+	Classes    []ClientClass
+	// ServeUnclassified answers clients of none of the classes too
+	ServeUnclassified bool
+}
+
+type configFile struct {
+	Scopes            []scopeConfig     `yaml:"scopes"`
+	RelayRules        []relayRuleConfig `yaml:"relayRules"`
+	Classes           []classConfig     `yaml:"classes"`
+	ServeUnclassified bool              `yaml:"serveUnclassified"`
+}
+
+// LoadConfigFile reads the configuration file at path
+func LoadConfigFile(path string) (FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileConfig{}, err
+	}
+	cfg, err := parseConfigFile(data)
+	if err != nil {
+		return FileConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func parseConfigFile(data []byte) (FileConfig, error) {
+	// YAML is a superset of JSON, so this reads either format
+	var file configFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return FileConfig{}, err
+	}
+	cfg := FileConfig{ServeUnclassified: file.ServeUnclassified}
+	for i, scope := range file.Scopes {
+		s, err := scope.scope()
+		if err != nil {
+			return FileConfig{}, fmt.Errorf("scope %d: %w", i+1, err)
+		}
+		cfg.Scopes = append(cfg.Scopes, s)
+	}
+	for i, rule := range file.RelayRules {
+		r, err := rule.rule(cfg.Scopes)
+		if err != nil {
+			return FileConfig{}, fmt.Errorf("relay rule %d: %w", i+1, err)
+		}
+		cfg.RelayRules = append(cfg.RelayRules, r)
+	}
+	for i, class := range file.Classes {
+		c, err := class.class()
+		if err != nil {
+			return FileConfig{}, fmt.Errorf("class %d: %w", i+1, err)
+		}
+		if _, dup := findClass(cfg.Classes, c.Name); dup {
+			return FileConfig{}, fmt.Errorf("class %d: duplicate name %q", i+1, c.Name)
+		}
+		cfg.Classes = append(cfg.Classes, c)
+	}
+	return cfg, nil
+}
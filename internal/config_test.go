@@ -0,0 +1,138 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rpe.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigFileScopes(t *testing.T) {
+	cfg, err := LoadConfigFile(writeConfigFile(t, testScopes))
+
+	assert.NoError(t, err)
+	scopes := cfg.Scopes
+	assert.Len(t, scopes, 2)
+	assert.Equal(t, "branch1", scopes[0].Name)
+	assert.Equal(t, "10.50.0.0/24", scopes[0].Subnet.String())
+	assert.Equal(t, "branch1.example.com", scopes[0].DomainName)
+	assert.Equal(t, "10.50.0.1", scopes[0].Router.String())
+	assert.Len(t, scopes[0].DNSServers, 2)
+	assert.Equal(t, 8*time.Hour, scopes[0].LeaseTime)
+	assert.Nil(t, scopes[1].Router)
+	assert.Zero(t, scopes[1].LeaseTime)
+	ip, err := scopes[0].Pool.Allocate("00:11:22:33:44:55", func(net.IP) bool { return false })
+	assert.NoError(t, err)
+	assert.Equal(t, "10.50.0.101", ip.String())
+	assert.Equal(t, "10.60.0.0/16", scopes[1].Name)
+}
+
+func TestLoadConfigFileJSON(t *testing.T) {
+	cfg, err := LoadConfigFile(writeConfigFile(t, `{"scopes": [{"cidr": "10.50.0.0/24", "range": "10.50.0.100-10.50.0.200"}]}`))
+
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Scopes, 1)
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	_, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	for _, content := range []string{
+		"scopes: {",
+		"scopes: [{cidr: 10.50.0.0/33, range: 10.50.0.100-10.50.0.200}]",
+		"scopes: [{cidr: fd00::/64, range: 10.50.0.100-10.50.0.200}]",
+		"scopes: [{cidr: 10.50.0.0/24}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.1.200}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.200-10.50.0.100}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, exclude: nope}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, domainName: ''}, {cidr: 10.60.0.0/24}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, router: nope}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, router: 10.60.0.1}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, dnsServers: [10.0.0.53, nope]}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, ntpServers: [ntp.example.com]}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, leaseTime: 8}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, leaseTime: 10ms}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, domainName: 'branch 1.example.com'}]",
+		"scopes: [{cidr: 10.50.0.0/24, range: 10.50.0.100-10.50.0.200, domainSearch: [example.com, '']}]",
+		"classes: [{vendorClass: [iAMT]}]",
+		"classes: [{name: amt}]",
+		"classes: [{name: amt, vendorClass: ['']}]",
+		"classes: [{name: amt, clientId: [0xzz]}]",
+		"classes: [{name: amt, uuid: [not-a-uuid]}]",
+		"classes: [{name: amt, oui: [00:1b]}]",
+		"classes: [{name: amt, oui: [00:1b:21], action: drop}]",
+		"classes: [{name: amt, oui: [00:1b:21], domainName: " + strings.Repeat("a", 256) + "}]",
+		"classes: [{name: amt, oui: [00:1b:21]}, {name: amt, vendorClass: [iAMT]}]",
+		"classes: [{name: amt, oui: [00:1b:21], domainName: lab_1.example.com}]",
+		"relayRules: [{circuitId: 0xzz}]",
+		"relayRules: [{remoteId: 0x}]",
+		"relayRules: [{subscriberId: 0x1}]",
+		"relayRules: [{action: drop}]",
+		"relayRules: [{scope: branch9}]",
+		"relayRules: [{domainName: ''}, {domainName: " + strings.Repeat("a", 256) + "}]",
+		"relayRules: [{domainName: -floor3.example.com}]",
+	} {
+		_, err := LoadConfigFile(writeConfigFile(t, content))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestLoadConfigFileRelayRules(t *testing.T) {
+	cfg, err := LoadConfigFile(writeConfigFile(t, testScopes+`
+relayRules:
+  - circuitId: Gi1/0/12
+    remoteId: 0x0a0b
+    domainName: floor3.example.com
+    scope: branch1
+  - remoteId: guest-switch
+    action: deny
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []RelayRule{
+		{CircuitID: []byte("Gi1/0/12"), RemoteID: []byte{0x0a, 0x0b}, DomainName: "floor3.example.com", Scope: cfg.Scopes[0]},
+		{RemoteID: []byte("guest-switch"), Deny: true},
+	}, cfg.RelayRules)
+}
+
+func TestLoadConfigFileDomainNames(t *testing.T) {
+	cfg, err := LoadConfigFile(writeConfigFile(t, `
+scopes:
+  - cidr: 10.50.0.0/24
+    range: 10.50.0.100-10.50.0.200
+    domainName: bücher.example.
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "xn--bcher-kva.example", cfg.Scopes[0].DomainName)
+}
+
+func TestLoadConfigFileClasses(t *testing.T) {
+	cfg, err := LoadConfigFile(writeConfigFile(t, testClasses))
+
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Classes, 3)
+	assert.Equal(t, []string{"8e2a5d1c-0b3f-4c6a-9d7e-112233445566"}, cfg.Classes[0].UUIDs)
+	assert.Equal(t, [][]byte{{0x01, 0xa4, 0xbb, 0x6d}}, cfg.Classes[1].ClientIDs)
+	assert.Equal(t, [][]byte{{0x00, 0x1b, 0x21}, {0xa4, 0xae, 0x11}}, cfg.Classes[1].OUIs)
+	assert.False(t, cfg.ServeUnclassified)
+
+	cfg, err = LoadConfigFile(writeConfigFile(t, testClasses+"serveUnclassified: true\n"))
+	assert.NoError(t, err)
+	assert.True(t, cfg.ServeUnclassified)
+}
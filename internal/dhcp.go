@@ -42,7 +42,7 @@ import (
 	"errors"
 	"log"
 	"net"
-	"strings"
+	"time"
 )
 
 type Packet []byte
@@ -56,29 +56,22 @@ type Option struct {
 }
 
 type NetworkEnumerator struct {
-	Interfaces func() ([]net.Interface, error)
-	Addrs      func(*net.Interface) ([]net.Addr, error)
+	Interfaces   func() ([]net.Interface, error)
+	Addrs        func(*net.Interface) ([]net.Addr, error)
+	DefaultRoute func() (string, error) // optional, name of the default route interface
 }
 
-type UDPConnection struct {
-	Connection net.Conn
+var validWiredInterfaces = map[string]bool{
+	"Ethernet": true, // Windows
+	"eth0":     true, // Linux legacy
+	"eno1":     true, // Linux
 }
 
-var validWiredInterfaces = map[string] bool {
-	"Ethernet": true,   // Windows
-	"eth0": 	true,	// Linux legacy
-	"eno1":		true,	// Linux
-}
-
-var domain string
-var assignIp string = "169.254.214.131"
-var clientMac string = "54-B2-03-89-D3-B9"
-var subnetMask net.IP = []byte{255, 255, 255, 0}
-var dns net.IP = []byte{8, 8, 8, 8}
 var padder [272]byte
 
 const (
-	destPort string = "68" // DHCP packets written to port 68
+	destPort   string = "68" // DHCP packets written to port 68
+	serverPort string = "67" // DHCP requests received on port 67
 
 	dhcpDiscover MessageType = 1
 	dhcpOffer    MessageType = 2
@@ -92,205 +85,199 @@ const (
 	bootRequest OpCode = 1
 	bootReply   OpCode = 2
 
+	Pad              OptionCode = 0
 	End              OptionCode = 255
 	OptionSubnetMask OptionCode = 1
 	OptionTimeOffset OptionCode = 2
+	OptionRouter     OptionCode = 3
 
 	OptionNameServer       OptionCode = 5
 	OptionDomainNameServer OptionCode = 6
 	OptionHostName         OptionCode = 12
 	OptionDomainName       OptionCode = 15
 	OptionDefaultTTL       OptionCode = 23
-
-	OptionRequestedIPAddress   OptionCode = 50
-	OptionIPLeaseTime          OptionCode = 51
-	OptionDHCPMessageType      OptionCode = 53
-	OptionServerIdentifier     OptionCode = 54
-	OptionParameterRequestList OptionCode = 55
-	OptionRenewalTime          OptionCode = 58
-	OptionRebindingTime        OptionCode = 59
-	OptionCLientIdentifier     OptionCode = 61
+	OptionNTPServers       OptionCode = 42
+
+	OptionRequestedIPAddress    OptionCode = 50
+	OptionIPLeaseTime           OptionCode = 51
+	OptionDHCPMessageType       OptionCode = 53
+	OptionServerIdentifier      OptionCode = 54
+	OptionOverload              OptionCode = 52
+	OptionParameterRequestList  OptionCode = 55
+	OptionMaximumMessageSize    OptionCode = 57
+	OptionRenewalTime           OptionCode = 58
+	OptionRebindingTime         OptionCode = 59
+	OptionVendorClassIdentifier OptionCode = 60
+	OptionCLientIdentifier      OptionCode = 61
+	OptionClientFQDN            OptionCode = 81
+	OptionRelayAgentInformation OptionCode = 82
+	OptionClientMachineID       OptionCode = 97
+	OptionDomainSearch          OptionCode = 119
 )
 
+// SendAck broadcasts a single unsolicited DHCPACK carrying domainName in
+// option 15, using the default configuration.
 func SendAck(domainName string) error {
-
-	domain = domainName
-
-	// DHCP packets are broadcasted.  Get broadcast address
-	broadcast, error := getBroadcastAddr(NetPkgEnumerator())
-
-	if error != nil {
-		return error
-	}
-	// Create a UDP connection
-	udp := UDPConnection{}
-	error = udp.Connect(broadcast, destPort)
-	if error != nil {
-		log.Println("failed dial step ", error)
-		return error
-	}
-	defer udp.Close()
-
-	// Initialize info for ack packet
-	ipv4Address, error := getIPV4Addr(NetPkgEnumerator())
-	if error != nil {
-		return error
-	}
-	serverIP := net.IP.To4(net.ParseIP(ipv4Address))
-	assignedIP := net.ParseIP(assignIp)
-	options, error := setDHCPOptions()
+	server, error := NewServer(NewConfig(domainName))
 	if error != nil {
 		return error
 	}
+	return server.SendAck(domainName)
+}
 
-	// Create ack packet
-	packet, error := createReplyPacket(dhcpAck, serverIP, assignedIP, options)
-	if error != nil {
-		return error
+// createReplyPacket builds a reply to req.  The transaction ID, hardware
+// address, flags and relay address are echoed from the request so the client
+// can match the reply.
+func createReplyPacket(req Packet, msgType MessageType, serverId net.IP, yIAddr net.IP, opitons []Option) (Packet, error) {
+	packet := NewPacket(bootReply)
+	packet.SetXId(req.XId())
+	packet.SetHType(req.HType())
+	packet.SetCHAddr(req.CHAddr())
+	packet.SetFlags(req.Flags())
+	packet.SetGIAddr(req.GIAddr())
+	if msgType == dhcpAck {
+		packet.SetCIAddr(req.CIAddr())
 	}
-
-	// Write ack packet
-	error = udp.Write(packet)
-	if error != nil {
-		log.Println(error)
+	// Relays broadcast NAKs, the client may no longer hold its address
+	if msgType == dhcpNack && !req.GIAddr().Equal(net.IPv4zero) {
+		packet.SetFlags([]byte{128, 0})
 	}
-
-	return error
-}
-
-func (udp *UDPConnection) Connect(ipaddr string, destport string) error {
-	var err error
-	udp.Connection, err = net.Dial("udp", ipaddr+":"+destport)
+	packet.SetYIAddr(yIAddr)
+	packet.AddOption(OptionDHCPMessageType, []byte{byte(msgType)})
+	packet.AddOption(OptionServerIdentifier, []byte(serverId))
+	// Requested options go first and whatever does not fit the size the
+	// client accepts is left out
+	reqOptions, err := req.Options()
 	if err != nil {
-		log.Println("failed dial step ", err)
-		return err
+		reqOptions = Options{}
 	}
-	return nil
-}
-
-func (udp *UDPConnection) Write(pkt Packet) error {
-	_, err := udp.Connection.Write(pkt)
-	if err != nil {
-		return err
+	maxLen := maxReplyLen(reqOptions)
+	// Relay agent information is echoed back last, RFC 3046 section 2.2
+	info, relayed := reqOptions[OptionRelayAgentInformation]
+	if relayed {
+		maxLen -= optionLen(len(info))
 	}
-	return nil
-}
-
-func (udp *UDPConnection) Close() error {
-	if udp.Connection == nil {
-		return errors.New("no connection to close")
+	packet, dropped := packOptions(packet, prioritiseOptions(opitons, reqOptions[OptionParameterRequestList]), maxLen)
+	for _, opt := range dropped {
+		log.Println("Option ", opt.Code, " left out of reply to ", req.CHAddr(), ", it exceeds the maximum message size")
 	}
-
-	err := udp.Connection.Close()
-	udp.Connection = nil
-	return err
+	if relayed {
+		packet.AddOption(OptionRelayAgentInformation, info)
+	}
+	packet.PadToMinSize()
+	return packet, nil
 }
 
-func createReplyPacket(msgType MessageType, serverId net.IP, yIAddr net.IP, opitons []Option) (Packet, error) {
-	packet := NewPacket(bootReply)
+// unsolicitedRequest stands in for the request an unsolicited reply answers,
+// using a fixed transaction ID and asking for a broadcast reply.
+func unsolicitedRequest(mac net.HardwareAddr) (Packet, error) {
+	req := NewPacket(bootRequest)
 	transactionID := IntToByteArray(10392900, 4)
 	if transactionID != nil {
-		packet.SetXId(transactionID)
+		req.SetXId(transactionID)
 	} else {
-		return packet, errors.New("invalid transaction Id")
+		return req, errors.New("invalid transaction Id")
 	}
 	flagsValue := IntToByteArray(32768, 2)
 	if flagsValue != nil {
-		packet.SetFlags(flagsValue)
+		req.SetFlags(flagsValue)
 	} else {
-		return packet, errors.New("invalid flags value")
+		return req, errors.New("invalid flags value")
 	}
-	packet.SetYIAddr(yIAddr)
-	packet.SetGIAddr(net.ParseIP("0.0.0.0"))
-	cMac, _ := net.ParseMAC(clientMac)
-	packet.SetCHAddr(cMac)
-	packet.AddOption(OptionDHCPMessageType, []byte{byte(msgType)})
-	packet.AddOption(OptionServerIdentifier, []byte(serverId))
-	for _, opt := range opitons {
-		packet.AddOption(opt.Code, opt.Value)
+	req.SetCHAddr(mac)
+	return req, nil
+}
+
+// replyAddr chooses where reply is sent.  Replies to relayed requests go to
+// the relay on the server port.  Clients that already hold an address in
+// ciaddr are unicast to; everyone else is broadcast to, since a client
+// without an address cannot answer the ARP a unicast would need.  That
+// covers clients asking for broadcast replies via the flags field.
+func replyAddr(reply Packet, broadcast string) (*net.UDPAddr, error) {
+	if giaddr := reply.GIAddr(); !giaddr.Equal(net.IPv4zero) {
+		return net.ResolveUDPAddr("udp4", net.JoinHostPort(giaddr.String(), serverPort))
 	}
-	packet.PadToMinSize()
-	return packet, nil
+	if ciaddr := reply.CIAddr(); !ciaddr.Equal(net.IPv4zero) {
+		return net.ResolveUDPAddr("udp4", net.JoinHostPort(ciaddr.String(), destPort))
+	}
+	return net.ResolveUDPAddr("udp4", net.JoinHostPort(broadcast, destPort))
 }
 
-func setDHCPOptions() ([]Option, error) {
+func setDHCPOptions(cfg Config) ([]Option, error) {
+	// Options that are not configured are left out rather than defaulted
 	var opts []Option
-	addDHCPOption(&opts, OptionSubnetMask, subnetMask.To4())
-	optionTimeOffset := IntToByteArray(0, 4)
-	if optionTimeOffset != nil {
-		addDHCPOption(&opts, OptionTimeOffset, optionTimeOffset)
-	} else {
-		return opts, errors.New("invalid Option Time Offset")
+	if cfg.SubnetMask != nil {
+		subnetMask, error := OptionIPList(OptionSubnetMask, cfg.SubnetMask)
+		if error != nil {
+			return opts, error
+		}
+		opts = append(opts, subnetMask)
 	}
-	addDHCPOption(&opts, OptionDomainNameServer, dns.To4())
-	addDHCPOption(&opts, OptionDomainName, []byte(domain))
-	addDHCPOption(&opts, OptionDefaultTTL, []byte{64})
-	optionIpLeaseTime := IntToByteArray(86400, 4)
-	if optionIpLeaseTime != nil {
-		addDHCPOption(&opts, OptionIPLeaseTime, optionIpLeaseTime)
-	} else {
-		return opts, errors.New("invalid Option IP Lease Time")
+	if cfg.Router != nil {
+		router, error := OptionIPList(OptionRouter, cfg.Router)
+		if error != nil {
+			return opts, error
+		}
+		opts = append(opts, router)
 	}
-	optionRenewalTime := IntToByteArray(43200, 4)
-	if optionRenewalTime != nil {
-		addDHCPOption(&opts, OptionRenewalTime, optionRenewalTime)
-	} else {
-		return opts, errors.New("invalid Option Renewal Time")
+	if len(cfg.DNSServers) > 0 {
+		dns, error := OptionIPList(OptionDomainNameServer, cfg.DNSServers...)
+		if error != nil {
+			return opts, error
+		}
+		opts = append(opts, dns)
 	}
-	optionRebindingTime := IntToByteArray(75600, 4)
-	if optionRebindingTime != nil {
-		addDHCPOption(&opts, OptionRebindingTime, optionRebindingTime)
-	} else {
-		return opts, errors.New("invalid Option Rebinding Time")
+	domainName, error := OptionDomain(OptionDomainName, cfg.DNSSuffix)
+	if error != nil {
+		return opts, error
+	}
+	opts = append(opts, domainName)
+	if len(cfg.DomainSearch) > 0 {
+		search, error := OptionDomainList(OptionDomainSearch, cfg.DomainSearch...)
+		if error != nil {
+			return opts, error
+		}
+		opts = append(opts, search)
 	}
+	opts = append(opts, OptionUint8(OptionDefaultTTL, 64))
+	if len(cfg.NTPServers) > 0 {
+		ntp, error := OptionIPList(OptionNTPServers, cfg.NTPServers...)
+		if error != nil {
+			return opts, error
+		}
+		opts = append(opts, ntp)
+	}
+	opts = append(opts,
+		OptionUint32(OptionIPLeaseTime, uint32(cfg.LeaseTime/time.Second)),
+		OptionUint32(OptionRenewalTime, uint32(cfg.RenewalTime/time.Second)),
+		OptionUint32(OptionRebindingTime, uint32(cfg.RebindTime/time.Second)),
+	)
 
 	return opts, nil
 }
 
-func addDHCPOption(array *[]Option, code OptionCode, value []byte) {
-	tmp := Option{Code: code, Value: value}
-	*array = append(*array, tmp)
-}
-
-func getIPV4Addr(ne NetworkEnumerator) (string, error) {
-	list, error := ne.Interfaces()
-	if error != nil {
-		log.Println("Failed getting network interfaces: ", error)
-	} else {
-		// Find "wired" network interface.  "Ethernet" for Windows, "eth0" or "eno1" for Linux
-		for _, iface := range list {
-			if validWiredInterfaces[iface.Name] {
-				addrs, error := ne.Addrs(&iface)  // get addresses associated with the interface
-				if error != nil {
-					log.Println("Failed getting interface addresses: ", error)
-				} else {
-					// Find Ipv4 address (won't contain a :) in list of addresses
-					for addrIndex := range addrs {
-						if strings.Contains(addrs[addrIndex].String(), ":") {
-							continue
-						}
-						// Strip off CIDR notation (/8, /16, etc.)
-						i := strings.Index(addrs[addrIndex].String(), "/")
-						return strings.TrimSpace(addrs[addrIndex].String()[:i]), error
-
-					}
-					return "", errors.New("no IPV4 address found")
-				}
-			}
+// optionValue returns the value of the first option with code in opts
+func optionValue(opts []Option, code OptionCode) []byte {
+	for _, opt := range opts {
+		if opt.Code == code {
+			return opt.Value
 		}
 	}
-	return "", error
+	return nil
 }
 
-func getBroadcastAddr(ne NetworkEnumerator) (string, error) {
-	subnet := "0.0.0"
-
-	localIp, error := getIPV4Addr(ne)
-	if error == nil {
-		subnet = localIp[:strings.LastIndex(localIp, ".")]
+// directedBroadcast sets all host bits of the subnet address to one
+func directedBroadcast(subnet *net.IPNet) net.IP {
+	ip := subnet.IP.To4()
+	mask := subnet.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
 	}
-
-	return subnet + ".255", error
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = ip[i] | ^mask[i]
+	}
+	return broadcast
 }
 
 func (pkt *Packet) PadToMinSize() {
@@ -299,31 +286,33 @@ func (pkt *Packet) PadToMinSize() {
 	}
 }
 
+// AddOption appends an option before the end option, splitting values
+// longer than 255 bytes as RFC 3396 describes
 func (pkt *Packet) AddOption(optCode OptionCode, value []byte) {
-	*pkt = append((*pkt)[:len(*pkt)-1], []byte{byte(optCode), byte(len(value))}...)
-	*pkt = append(*pkt, value...)
+	*pkt = appendOption((*pkt)[:len(*pkt)-1], optCode, value)
 	*pkt = append(*pkt, byte(End))
 }
 
 func NewPacket(opCode OpCode) Packet {
 	packet := make(Packet, 241)
 	packet.SetOpCode(opCode)
-	packet.SetHType(1)                        // Ethernet
-	packet.SetCookie([]byte{99, 130, 83, 99}) // DHCP "Magic Cookie"
+	packet.SetHType(1)            // Ethernet
+	packet.SetCookie(magicCookie) // DHCP "Magic Cookie"
 	packet[240] = byte(End)
 
 	return packet
 }
 
+// IntToByteArray encodes num in network byte order
 func IntToByteArray(num int, bytes int) []byte {
 	byteArray := []byte(nil)
 	switch bytes {
 	case 2:
 		byteArray = make([]byte, bytes)
-		binary.LittleEndian.PutUint16(byteArray, uint16(num))
+		binary.BigEndian.PutUint16(byteArray, uint16(num))
 	case 4:
 		byteArray = make([]byte, bytes)
-		binary.LittleEndian.PutUint32(byteArray, uint32(num))
+		binary.BigEndian.PutUint32(byteArray, uint32(num))
 	default:
 		log.Println("IntToByteArray() - Invalid byte count request: ", bytes)
 	}
@@ -332,8 +321,9 @@ func IntToByteArray(num int, bytes int) []byte {
 
 func NetPkgEnumerator() NetworkEnumerator {
 	return NetworkEnumerator{
-		Interfaces: net.Interfaces,
-		Addrs:      (*net.Interface).Addrs,
+		Interfaces:   net.Interfaces,
+		Addrs:        (*net.Interface).Addrs,
+		DefaultRoute: defaultRouteInterface,
 	}
 }
 
@@ -357,6 +347,9 @@ func (p Packet) CHAddr() net.HardwareAddr {
 }
 func (p Packet) Cookie() []byte { return p[236:240] }
 
+// Broadcast reports whether the broadcast bit of the flags field is set.
+func (p Packet) Broadcast() bool { return p.Flags()[0]&0x80 != 0 }
+
 func (p Packet) SetOpCode(c OpCode)      { p[0] = byte(c) }
 func (p Packet) SetHType(hType byte)     { p[1] = hType }
 func (p Packet) SetCookie(cookie []byte) { copy(p.Cookie(), cookie) }
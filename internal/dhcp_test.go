@@ -1,52 +1,45 @@
 package rpe
 
 import (
+	"bytes"
+	"context"
 	//"log"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"net"
 )
-func TestConnect(t *testing.T) {
-	_, client := net.Pipe()
-
-	udp := UDPConnection{Connection: client}
-	err := udp.Connect("", "")
-	defer udp.Close()
-	assert.NoError(t, err)
-}
 
 func TestWrite(t *testing.T) {
-	server, client := net.Pipe()
+	server, err := ListenUDP(false)(context.Background(), NetworkInterface{}, "127.0.0.1:0")
+	assert.NoError(t, err)
 	defer server.Close()
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer client.Close()
 
-	udp := UDPConnection{
-		Connection: client,
-	}
-	defer udp.Close() // should close client pipe
 	pkt := NewPacket(bootReply)
-	go func() {
-		err := udp.Write(pkt)
-		assert.NoError(t, err)
-	}()
+	_, err = server.WriteTo(pkt, Peer{Addr: client.LocalAddr().(*net.UDPAddr)})
+	assert.NoError(t, err)
 
-	
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
 	readbuff := make([]byte, 65535)
-	n, err := server.Read(readbuff)
+	n, err := client.Read(readbuff)
 	assert.Equal(t, len(pkt), len(readbuff[:n]))
 	assert.NoError(t, err)
 }
 
 func TestIntToByteArray(t *testing.T) {
-	want := []byte{128, 81, 1, 0}
+	want := []byte{0, 1, 81, 128}
 
 	rcvd := IntToByteArray(86400, 4)
 
 	assert.Equal(t, want, rcvd)
 }
 func Test2IntToByteArray(t *testing.T) {
-	want := []byte{0, 128}
+	want := []byte{128, 0}
 
 	rcvd := IntToByteArray(32768, 2)
 
@@ -76,32 +69,52 @@ func TestNewPacket(t *testing.T) {
 	assert.Equal(t, want_lastbyte, End)
 }
 
-func TestAddDHCPOption(t *testing.T) {
+func TestOptionUint8(t *testing.T) {
 	rcvd := []Option{}
 	want := []Option{}
 
 	want = append(want, Option{Code: OptionDefaultTTL, Value: []byte{64}})
-	addDHCPOption(&rcvd, OptionDefaultTTL, []byte{64})
+	rcvd = append(rcvd, OptionUint8(OptionDefaultTTL, 64))
 
 	assert.Equal(t, want, rcvd)
 }
 
 func TestSetDHCPOption(t *testing.T) {
 	want := []Option{}
-	want = append(want, Option{Code: OptionSubnetMask, Value: subnetMask.To4()})
-	want = append(want, Option{Code: OptionTimeOffset, Value: IntToByteArray(0, 4)})
-	want = append(want, Option{Code: OptionDomainNameServer, Value: dns.To4()})
-	want = append(want, Option{Code: OptionDomainName, Value: []byte(domain)})
+	cfg := NewConfig("test.com")
+	cfg.Router = net.IPv4(10, 0, 0, 1)
+	cfg.DNSServers = []net.IP{net.IPv4(10, 0, 0, 53)}
+	cfg.NTPServers = []net.IP{net.IPv4(10, 0, 0, 123), net.IPv4(10, 0, 1, 123)}
+	cfg.DomainSearch = []string{"test.com"}
+	want = append(want, Option{Code: OptionSubnetMask, Value: cfg.SubnetMask.To4()})
+	want = append(want, Option{Code: OptionRouter, Value: []byte{10, 0, 0, 1}})
+	want = append(want, Option{Code: OptionDomainNameServer, Value: []byte{10, 0, 0, 53}})
+	want = append(want, Option{Code: OptionDomainName, Value: []byte(cfg.DNSSuffix)})
+	want = append(want, Option{Code: OptionDomainSearch, Value: []byte("\x04test\x03com\x00")})
 	want = append(want, Option{Code: OptionDefaultTTL, Value: []byte{64}})
+	want = append(want, Option{Code: OptionNTPServers, Value: []byte{10, 0, 0, 123, 10, 0, 1, 123}})
 	want = append(want, Option{Code: OptionIPLeaseTime, Value: IntToByteArray(86400, 4)})
 	want = append(want, Option{Code: OptionRenewalTime, Value: IntToByteArray(43200, 4)})
 	want = append(want, Option{Code: OptionRebindingTime, Value: IntToByteArray(75600, 4)})
 
-	rcvd, _ := setDHCPOptions()
+	rcvd, _ := setDHCPOptions(cfg)
 
 	assert.Equal(t, want, rcvd)
 }
 
+func TestSetDHCPOptionUnconfigured(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.SubnetMask = nil
+
+	rcvd, error := setDHCPOptions(cfg)
+
+	assert.NoError(t, error)
+	for _, code := range []OptionCode{OptionSubnetMask, OptionTimeOffset, OptionRouter, OptionDomainNameServer, OptionNTPServers, OptionDomainSearch} {
+		assert.Nil(t, optionValue(rcvd, code), code)
+	}
+	assert.Equal(t, []byte("test.com"), optionValue(rcvd, OptionDomainName))
+}
+
 func TestAddOption(t *testing.T) {
 	tstIP := "1.1.1.1"
 	p := NewPacket(bootReply)
@@ -127,21 +140,21 @@ func TestConsts(t *testing.T) {
 	wantPort := "68"
 	assert.Equal(t, wantPort, destPort)
 
-	wantMsgType := MessageType(1) 
+	wantMsgType := MessageType(1)
 	assert.Equal(t, wantMsgType, dhcpDiscover)
-	wantMsgType = MessageType(2) 
+	wantMsgType = MessageType(2)
 	assert.Equal(t, wantMsgType, dhcpOffer)
-	wantMsgType = MessageType(3) 
+	wantMsgType = MessageType(3)
 	assert.Equal(t, wantMsgType, dhcpRequest)
-	wantMsgType = MessageType(4) 
+	wantMsgType = MessageType(4)
 	assert.Equal(t, wantMsgType, dhcpDecline)
-	wantMsgType = MessageType(5) 
+	wantMsgType = MessageType(5)
 	assert.Equal(t, wantMsgType, dhcpAck)
-	wantMsgType = MessageType(6) 
+	wantMsgType = MessageType(6)
 	assert.Equal(t, wantMsgType, dhcpNack)
-	wantMsgType = MessageType(7) 
+	wantMsgType = MessageType(7)
 	assert.Equal(t, wantMsgType, dhcpRelease)
-	wantMsgType = MessageType(8) 
+	wantMsgType = MessageType(8)
 	assert.Equal(t, wantMsgType, dhcpInform)
 
 	wantOpCode := OptionCode(255)
@@ -189,7 +202,6 @@ func TestNetPkgEnumerator(t *testing.T) {
 	assert.IsType(t, want.Addrs, rcvd.Addrs)
 }
 
-
 func TestChkSetsGets(t *testing.T) {
 	yiaddr := "10.20.30.5"
 	giaddr := "0.0.0.0"
@@ -216,9 +228,9 @@ func TestChkSetsGets(t *testing.T) {
 	assert.Equal(t, byte(2), p.HType())    // 1 - ethernet
 	assert.Equal(t, byte(6), p.HLen())     // 6 - length of MAC
 	assert.Equal(t, byte(1), p.Hops())
-	assert.Equal(t, []byte{68, 149, 158, 0}, p.XId()) // arbitrary transaction id of 10392900
+	assert.Equal(t, []byte{0, 158, 149, 68}, p.XId()) // arbitrary transaction id of 10392900
 	assert.Equal(t, []byte{1, 1}, p.Secs())
-	assert.Equal(t, []byte{0, 128}, p.Flags()) // flag value of  32768
+	assert.Equal(t, []byte{128, 0}, p.Flags()) // flag value of  32768
 	assert.Equal(t, "1.1.1.1", p.CIAddr().String())
 	assert.Equal(t, yiaddr, p.YIAddr().String())
 	assert.Equal(t, "1.1.1.1", p.SIAddr().String())
@@ -227,9 +239,9 @@ func TestChkSetsGets(t *testing.T) {
 	assert.Equal(t, []byte{99, 130, 83, 99}, p.Cookie())
 }
 
-
 var tstIP = "10.20.30.34"
 var tstBroadcast = "10.20.30.255"
+
 type mockIPV4Addr struct {
 }
 
@@ -240,7 +252,7 @@ func (mn mockIPV4Addr) Network() string {
 	return "tcp"
 }
 func (ma mockIPV4Addr) String() string {
-	return tstIP+"/24"
+	return tstIP + "/24"
 }
 
 func (mn mockIPV6Addr) Network() string {
@@ -255,7 +267,7 @@ func TestSendAck(t *testing.T) {
 
 }
 
-func TestGetIPV4Addr(t *testing.T) {
+func TestSelectInterfaceAddr(t *testing.T) {
 
 	//create the mock Interfaces list
 	parsedMac, _ := net.ParseMAC("DE:AD:BE:EF:FF:FF")
@@ -279,13 +291,12 @@ func TestGetIPV4Addr(t *testing.T) {
 		Addrs:      func(*net.Interface) ([]net.Addr, error) { return []net.Addr{myMockIPV6Addr, myMockIPV4Addr}, nil },
 	}
 
-   
 	want := tstIP
-	rcvd, _ := getIPV4Addr(myMockNetEnum)
-	assert.Equal(t, want, rcvd)
-	
+	iface, _ := selectInterface(myMockNetEnum, "")
+	assert.Equal(t, want, iface.Address.IP.String())
+
 }
-func TestGetBoradcastAddr(t *testing.T) {
+func TestSelectInterfaceBroadcast(t *testing.T) {
 
 	//create the mock Interfaces list
 	parsedMac, _ := net.ParseMAC("DE:AD:BE:EF:FF:FF")
@@ -308,15 +319,15 @@ func TestGetBoradcastAddr(t *testing.T) {
 		Interfaces: func() ([]net.Interface, error) { return myMockInterfaces, nil },
 		Addrs:      func(*net.Interface) ([]net.Addr, error) { return []net.Addr{myMockIPV6Addr, myMockIPV4Addr}, nil },
 	}
-   
+
 	want := tstBroadcast
-	rcvd, _ := getBroadcastAddr(myMockNetEnum)
-	assert.Equal(t, want, rcvd)
-	
+	iface, _ := selectInterface(myMockNetEnum, "")
+	assert.Equal(t, want, directedBroadcast(iface.Address).String())
+
 }
 
-func TestCreateReplyPacket (t *testing.T) {
-	tstassignip  := "10.20.30.131"
+func TestCreateReplyPacket(t *testing.T) {
+	tstassignip := "10.20.30.131"
 	giaddr := "0.0.0.0"
 	chaddr := "54:b2:03:89:d3:b9"
 
@@ -342,20 +353,22 @@ func TestCreateReplyPacket (t *testing.T) {
 		Addrs:      func(*net.Interface) ([]net.Addr, error) { return []net.Addr{myMockIPV6Addr, myMockIPV4Addr}, nil },
 	}
 
-	ipv4Address, _ := getIPV4Addr(myMockNetEnum)
-	serverIP := net.IP.To4(net.ParseIP(ipv4Address))
+	iface, _ := selectInterface(myMockNetEnum, "")
+	serverIP := iface.Address.IP
 	assignedIP := net.ParseIP(tstassignip)
-	options, _ := setDHCPOptions()
+	options, _ := setDHCPOptions(NewConfig("test.com"))
+	clientMac, _ := net.ParseMAC(chaddr)
+	req, _ := unsolicitedRequest(clientMac)
 
-	p, _ := createReplyPacket(dhcpAck, serverIP, assignedIP, options)
+	p, _ := createReplyPacket(req, dhcpAck, serverIP, assignedIP, options)
 
 	assert.Equal(t, OpCode(2), p.OpCode()) // 2 - bootrequest
 	assert.Equal(t, byte(1), p.HType())    // 1 - ethernet
 	assert.Equal(t, byte(6), p.HLen())     // 6 - length of MAC
 	assert.Equal(t, byte(0), p.Hops())
-	assert.Equal(t, []byte{68, 149, 158, 0}, p.XId()) // arbitrary transaction id of 10392900
+	assert.Equal(t, []byte{0, 158, 149, 68}, p.XId()) // arbitrary transaction id of 10392900
 	assert.Equal(t, []byte{0, 0}, p.Secs())
-	assert.Equal(t, []byte{0, 128}, p.Flags()) // flag value of  32768
+	assert.Equal(t, []byte{128, 0}, p.Flags()) // flag value of  32768
 	assert.Equal(t, "0.0.0.0", p.CIAddr().String())
 	assert.Equal(t, tstassignip, p.YIAddr().String())
 	assert.Equal(t, "0.0.0.0", p.SIAddr().String())
@@ -364,3 +377,116 @@ func TestCreateReplyPacket (t *testing.T) {
 	assert.Equal(t, []byte{99, 130, 83, 99}, p.Cookie())
 
 }
+
+func newTestRequest(msgType MessageType) Packet {
+	p := NewPacket(bootRequest)
+	p.SetXId([]byte{1, 2, 3, 4})
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	p.SetCHAddr(mac)
+	p.AddOption(OptionDHCPMessageType, []byte{byte(msgType)})
+	return p
+}
+
+func TestCreateReplyPacketFromRequest(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestRequest(dhcpRequest)
+	req.SetFlags([]byte{128, 0})
+	req.SetCIAddr(net.ParseIP("10.20.30.99"))
+	req.SetGIAddr(net.ParseIP("10.20.1.1"))
+
+	offer, _ := createReplyPacket(req, dhcpOffer, serverIP, net.ParseIP("10.20.30.131"), nil)
+	ack, _ := createReplyPacket(req, dhcpAck, serverIP, net.ParseIP("10.20.30.131"), nil)
+
+	for _, p := range []Packet{offer, ack} {
+		assert.Equal(t, []byte{1, 2, 3, 4}, p.XId())
+		assert.Equal(t, "00:11:22:33:44:55", p.CHAddr().String())
+		assert.Equal(t, []byte{128, 0}, p.Flags())
+		assert.True(t, p.Broadcast())
+		assert.Equal(t, "10.20.1.1", p.GIAddr().String())
+		assert.Equal(t, "10.20.30.131", p.YIAddr().String())
+	}
+	assert.Equal(t, "0.0.0.0", offer.CIAddr().String())
+	assert.Equal(t, "10.20.30.99", ack.CIAddr().String())
+}
+
+func TestReplyAddr(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+	addr, err := replyAddr(req, "10.20.30.255")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.255:68", addr.String())
+
+	req.SetFlags([]byte{128, 0})
+	addr, _ = replyAddr(req, "10.20.30.255")
+	assert.Equal(t, "10.20.30.255:68", addr.String())
+
+	req.SetCIAddr(net.ParseIP("10.20.30.99"))
+	addr, _ = replyAddr(req, "10.20.30.255")
+	assert.Equal(t, "10.20.30.99:68", addr.String())
+}
+
+func TestReplyAddrRelayed(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+	req.SetCIAddr(net.ParseIP("10.50.0.99"))
+	req.SetGIAddr(net.ParseIP("10.50.0.1"))
+
+	addr, err := replyAddr(req, "10.20.30.255")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.50.0.1:67", addr.String())
+}
+
+func TestCreateReplyPacketEchoesRelayAgentInfo(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+	req.SetGIAddr(net.ParseIP("10.50.0.1"))
+	req.AddOption(OptionRelayAgentInformation, []byte{relayCircuitID, 1, 'p'})
+
+	offer, _ := createReplyPacket(req, dhcpOffer, net.ParseIP("10.20.30.34"), net.ParseIP("10.50.0.101"), []Option{{Code: OptionDomainName, Value: []byte("test.com")}})
+
+	options, _ := offer.Options()
+	assert.Equal(t, []byte{relayCircuitID, 1, 'p'}, options[OptionRelayAgentInformation])
+	// echoed last, after the options of the reply
+	end := bytes.LastIndexByte(offer, byte(End))
+	assert.Equal(t, []byte{byte(OptionRelayAgentInformation), 3, relayCircuitID, 1, 'p'}, []byte(offer[end-5:end]))
+}
+
+func TestCreateReplyPacketRelayedNak(t *testing.T) {
+	req := newTestRequest(dhcpRequest)
+	nak, _ := createReplyPacket(req, dhcpNack, net.ParseIP("10.20.30.34"), net.IPv4zero, nil)
+	assert.False(t, nak.Broadcast())
+
+	req.SetGIAddr(net.ParseIP("10.50.0.1"))
+	nak, _ = createReplyPacket(req, dhcpNack, net.ParseIP("10.20.30.34"), net.IPv4zero, nil)
+	assert.True(t, nak.Broadcast())
+}
+
+func TestDirectedBroadcast(t *testing.T) {
+	for cidr, want := range map[string]string{
+		"10.20.30.34/24":  "10.20.30.255",
+		"10.20.30.34/16":  "10.20.255.255",
+		"10.20.30.34/23":  "10.20.31.255",
+		"10.20.30.34/28":  "10.20.30.47",
+		"192.168.1.1/32":  "192.168.1.1",
+		"172.16.200.9/12": "172.31.255.255",
+	} {
+		ip, subnet, _ := net.ParseCIDR(cidr)
+		subnet.IP = ip
+
+		assert.Equal(t, want, directedBroadcast(subnet).String(), cidr)
+	}
+}
+
+func TestDirectedBroadcastIPv6Mask(t *testing.T) {
+	subnet := &net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(120, 128)}
+
+	assert.Equal(t, "10.1.2.255", directedBroadcast(subnet).String())
+}
+
+func TestSelectInterfaceNoAddr(t *testing.T) {
+	ne := NetworkEnumerator{
+		Interfaces: func() ([]net.Interface, error) { return nil, nil },
+	}
+
+	_, err := selectInterface(ne, "")
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// maxDomainLen is the longest name in dotted form, 255 bytes on the
+	// wire less the first length byte and the root label, RFC 1035 section
+	// 2.3.4
+	maxDomainLen = 253
+	maxLabelLen  = 63
+)
+
+// NormalizeDomain checks that name is a valid DNS domain name and returns
+// it in the form sent to clients, without the trailing dot of the root.
+// Internationalised names are converted to punycode, which lower cases
+// them, ASCII names keep their case.  Labels must follow the letter, digit,
+// hyphen rule of RFC 1123.
+func NormalizeDomain(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("domain name is empty")
+	}
+	ascii := strings.TrimSuffix(name, ".")
+	converted, err := idna.Lookup.ToASCII(ascii)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+	}
+	if !isASCII(ascii) {
+		ascii = converted
+	}
+	if len(ascii) > maxDomainLen {
+		return "", fmt.Errorf("invalid domain name %q: %d bytes, at most %d allowed", name, len(ascii), maxDomainLen)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if err := checkLabel(label); err != nil {
+			return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+		}
+	}
+	return ascii, nil
+}
+
+func isASCII(s string) bool {
+	for _, c := range []byte(s) {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func checkLabel(label string) error {
+	if label == "" {
+		return errors.New("empty label")
+	}
+	if len(label) > maxLabelLen {
+		return fmt.Errorf("label %q is longer than %d bytes", label, maxLabelLen)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %q starts or ends with a hyphen", label)
+	}
+	for _, c := range []byte(label) {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+			return fmt.Errorf("label %q contains %q", label, c)
+		}
+	}
+	return nil
+}
+
+// ParseDomainList parses a comma separated list of domain names as given on
+// the command line, normalising each.  An empty list yields no names.
+func ParseDomainList(list string) ([]string, error) {
+	var names []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, err := NormalizeDomain(item)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// compressionLimit is the first offset a compression pointer cannot reach
+const compressionLimit = 0x4000
+
+// encodeDomainList encodes names in DNS wire format, each ending in the
+// root label.  Suffixes already written are replaced by pointers to them,
+// RFC 1035 section 4.1.4, offsets counting from the start of the list as
+// RFC 3397 requires.  The names must have been normalised.
+func encodeDomainList(names []string) []byte {
+	var b []byte
+	offsets := make(map[string]int)
+	for _, name := range names {
+		labels := strings.Split(name, ".")
+		compressed := false
+		for i := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, ok := offsets[suffix]; ok {
+				b = append(b, 0xc0|byte(offset>>8), byte(offset))
+				compressed = true
+				break
+			}
+			if len(b) < compressionLimit {
+				offsets[suffix] = len(b)
+			}
+			b = append(b, byte(len(labels[i])))
+			b = append(b, labels[i]...)
+		}
+		if !compressed {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// decodeDomainList reads the names encodeDomainList writes
+func decodeDomainList(b []byte) ([]string, error) {
+	var names []string
+	for i := 0; i < len(b); {
+		name, next, err := decodeDomainName(b, i)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		i = next
+	}
+	return names, nil
+}
+
+// decodeDomainName reads the compressed name at b[i:] and returns it with
+// the offset of what follows it.  Pointers must point before the labels
+// that led to them, so a malformed list cannot loop.
+func decodeDomainName(b []byte, i int) (string, int, error) {
+	var labels []string
+	next, limit := -1, i
+	for {
+		if i >= len(b) {
+			return "", 0, errors.New("domain name truncated")
+		}
+		length := int(b[i])
+		if length == 0 {
+			i++
+			break
+		}
+		if length&0xc0 == 0xc0 {
+			if i+1 >= len(b) {
+				return "", 0, errors.New("domain name truncated")
+			}
+			offset := (length&0x3f)<<8 | int(b[i+1])
+			if offset >= limit {
+				return "", 0, fmt.Errorf("compression pointer to %d does not point back", offset)
+			}
+			if next < 0 {
+				next = i + 2
+			}
+			i, limit = offset, offset
+			continue
+		}
+		if length > maxLabelLen {
+			return "", 0, fmt.Errorf("invalid label length %d", length)
+		}
+		if i+1+length > len(b) {
+			return "", 0, errors.New("domain name truncated")
+		}
+		labels = append(labels, string(b[i+1:i+1+length]))
+		i += 1 + length
+	}
+	if next < 0 {
+		next = i
+	}
+	return strings.Join(labels, "."), next, nil
+}
@@ -0,0 +1,111 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	for name, expected := range map[string]string{
+		"example.com":           "example.com",
+		"Site1.Example.com.":    "Site1.Example.com",
+		"vpro-1.example.com":    "vpro-1.example.com",
+		"bücher.example":        "xn--bcher-kva.example",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+		"ÉCOLE.example.fr":      "xn--cole-9oa.example.fr",
+		strings.Repeat("a", 63): strings.Repeat("a", 63),
+		"localdomain":           "localdomain",
+	} {
+		normalized, err := NormalizeDomain(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, normalized, name)
+	}
+}
+
+func TestNormalizeDomainInvalid(t *testing.T) {
+	long := strings.Repeat(strings.Repeat("a", 63)+".", 4)
+	for _, name := range []string{
+		"",
+		".",
+		"example.com..",
+		"a..example.com",
+		".example.com",
+		"my site.example.com",
+		" example.com",
+		"site_1.example.com",
+		"-site.example.com",
+		"site-.example.com",
+		"ex%ample.com",
+		strings.Repeat("a", 64) + ".example.com",
+		long[:len(long)-1],
+	} {
+		_, err := NormalizeDomain(name)
+		assert.Error(t, err, name)
+	}
+	// 253 bytes is the most that fits the wire format
+	_, err := NormalizeDomain(long[:253])
+	assert.NoError(t, err)
+}
+
+func TestOptionDomain(t *testing.T) {
+	opt, err := OptionDomain(OptionDomainName, "bücher.example.")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Option{Code: OptionDomainName, Value: []byte("xn--bcher-kva.example")}, opt)
+	_, err = OptionDomain(OptionDomainName, "my site")
+	assert.Error(t, err)
+}
+
+func TestParseDomainList(t *testing.T) {
+	names, err := ParseDomainList(" site1.example.com, bücher.example.,,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"site1.example.com", "xn--bcher-kva.example"}, names)
+
+	names, err = ParseDomainList("")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	_, err = ParseDomainList("example.com, my site")
+	assert.Error(t, err)
+}
+
+func TestEncodeDomainList(t *testing.T) {
+	// the example of RFC 3397 section 2
+	encoded := encodeDomainList([]string{"eng.apple.com", "marketing.apple.com"})
+
+	assert.Equal(t, []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04"), encoded)
+	names, err := decodeDomainList(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eng.apple.com", "marketing.apple.com"}, names)
+}
+
+func TestEncodeDomainListRepeated(t *testing.T) {
+	names := []string{"example.com", "site1.Example.com", "example.com", "example.net"}
+
+	encoded := encodeDomainList(names)
+
+	assert.Equal(t, []byte("\x07example\x03com\x00\x05site1\xc0\x00\xc0\x00\x07example\x03net\x00"), encoded)
+	decoded, err := decodeDomainList(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "site1.example.com", "example.com", "example.net"}, decoded)
+}
+
+func TestDecodeDomainListInvalid(t *testing.T) {
+	for _, encoded := range []string{
+		"\x07example\x03com",
+		"\x07exam",
+		"\xc0",
+		"\xc0\x00",                   // points at itself
+		"\x03com\x00\x03www\xc0\x05", // points into its own name
+		"\x40",
+	} {
+		_, err := decodeDomainList([]byte(encoded))
+		assert.Error(t, err, encoded)
+	}
+}
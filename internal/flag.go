@@ -7,21 +7,68 @@ package rpe
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 )
 
 type Flags struct {
-	DNSSuffix string
-	Port      int
+	DNSSuffix        string
+	Port             int
+	APIHost          string
+	APIToken         string // environment only, like RPSToken
+	ReservationsFile string
+	ConfigFile       string
+	DNSServers       string
+	Router           string
+	NTPServers       string
+	DomainSearch     string
+	Interface        string
+	LimitedBroadcast bool
+	LeaseFile        string
+	PoolRange        string
+	PoolExclude      string
+	Probe            bool
+	Proxy            bool
+	RawSocket        bool
+	AMTOnly          bool
+	RPSURL           string
+	MPSURL           string
+	RPSToken         string // environment only, to keep it out of process listings
+	CertFile         string
+	CertPassword     string // environment only, like RPSToken
+	StrictSuffix     bool
 }
 
 func NewFlags() *Flags {
 	flags := &Flags{}
 
 	flag.IntVar(&flags.Port, "p", LookupEnvOrInt("PORT", 3050), "Port to run RPE service")
+	flag.StringVar(&flags.APIHost, "host", LookupEnvOrString("API_HOST", "127.0.0.1"), "Address to run RPE service on")
+	flags.APIToken = LookupEnvOrString("API_TOKEN", "")
 	flag.StringVar(&flags.DNSSuffix, "d", LookupEnvOrString("DNS_SUFFIX", ""), "DNS Suffix")
+	flag.StringVar(&flags.ReservationsFile, "r", LookupEnvOrString("RESERVATIONS_FILE", ""), "Reservations file")
+	flag.StringVar(&flags.ConfigFile, "c", LookupEnvOrString("CONFIG_FILE", ""), "Configuration file")
+	flag.StringVar(&flags.DNSServers, "dns", LookupEnvOrString("DNS_SERVERS", ""), "Comma separated DNS servers")
+	flag.StringVar(&flags.Router, "router", LookupEnvOrString("ROUTER", ""), "Default gateway")
+	flag.StringVar(&flags.NTPServers, "ntp", LookupEnvOrString("NTP_SERVERS", ""), "Comma separated NTP servers")
+	flag.StringVar(&flags.DomainSearch, "search", LookupEnvOrString("DOMAIN_SEARCH", ""), "Comma separated domain search list")
+	flag.StringVar(&flags.Interface, "i", LookupEnvOrString("INTERFACE", ""), "Comma separated network interface names, indexes or CIDRs")
+	flag.StringVar(&flags.LeaseFile, "l", LookupEnvOrString("LEASE_FILE", ""), "Lease database file")
+	flag.StringVar(&flags.PoolRange, "a", LookupEnvOrString("POOL_RANGE", ""), "Address pool range")
+	flag.StringVar(&flags.PoolExclude, "x", LookupEnvOrString("POOL_EXCLUDE", ""), "Addresses excluded from the pool")
+	flag.BoolVar(&flags.Probe, "probe", LookupEnvOrBool("PROBE", false), "Probe pool addresses before offering them")
+	flag.BoolVar(&flags.Proxy, "proxy", LookupEnvOrBool("PROXY", false), "Only add the DNS suffix to leases of the site DHCP server")
+	flag.BoolVar(&flags.RawSocket, "raw", LookupEnvOrBool("RAW_SOCKET", false), "Use a raw socket to reach clients without an address")
+	flag.StringVar(&flags.RPSURL, "rps", LookupEnvOrString("RPS_URL", ""), "RPS REST API URL")
+	flag.StringVar(&flags.MPSURL, "mps", LookupEnvOrString("MPS_URL", ""), "MPS REST API URL")
+	flags.RPSToken = LookupEnvOrString("RPS_TOKEN", "")
+	flag.StringVar(&flags.CertFile, "cert", LookupEnvOrString("PROVISIONING_CERT", ""), "AMT provisioning certificate")
+	flags.CertPassword = LookupEnvOrString("PROVISIONING_CERT_PASSWORD", "")
+	flag.BoolVar(&flags.StrictSuffix, "strict", LookupEnvOrBool("STRICT_SUFFIX", false), "Refuse DNS suffixes the provisioning certificate does not cover")
+	flag.BoolVar(&flags.AMTOnly, "amt", LookupEnvOrBool("AMT_ONLY", false), "Only answer Intel AMT clients")
+	flag.BoolVar(&flags.LimitedBroadcast, "b", LookupEnvOrBool("LIMITED_BROADCAST", false), "Broadcast to 255.255.255.255")
 
 	return flags
 }
@@ -34,6 +81,26 @@ func (f *Flags) ParseFlags() error {
 		log.Println(f.Usage())
 		return errors.New("missing required flags")
 	}
+	suffix, err := NormalizeDomain(f.DNSSuffix)
+	if err != nil {
+		return fmt.Errorf("-d: %w", err)
+	}
+	f.DNSSuffix = suffix
+	if _, err := ParseDomainList(f.DomainSearch); err != nil {
+		return fmt.Errorf("-search: %w", err)
+	}
+	if (f.RPSURL == "") != (f.MPSURL == "") {
+		return errors.New("-rps: the domains of RPS and the devices of MPS are needed, give both -rps and -mps")
+	}
+	for _, list := range []struct{ flag, value string }{{"-dns", f.DNSServers}, {"-router", f.Router}, {"-ntp", f.NTPServers}} {
+		ips, err := ParseIPList(list.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", list.flag, err)
+		}
+		if list.flag == "-router" && len(ips) > 1 {
+			return errors.New("-router: only one default gateway can be given")
+		}
+	}
 	return nil
 }
 func (f *Flags) Usage() string {
@@ -41,7 +108,46 @@ func (f *Flags) Usage() string {
 	usage = usage + "Usage: rpe [OPTIONS]\n\n"
 	usage = usage + "OPTIONS:\n"
 	usage = usage + "  -p  int     port to listen on (override PORT env var)\n"
-	usage = usage + "  -d  string  dns suffix to broadcast in option 15 of DHCP (override DNS_SUFFIX env var)\n\n"
+	usage = usage + "  -host       address the control API listens on, 127.0.0.1 by default, requests need\n"
+	usage = usage + "              the API_TOKEN env var as bearer token if set (override API_HOST env var)\n"
+	usage = usage + "  -d  string  dns suffix to broadcast in option 15 of DHCP (override DNS_SUFFIX env var)\n"
+	usage = usage + "  -r  string  YAML or JSON file of per-device reservations (override RESERVATIONS_FILE env var)\n"
+	usage = usage + "  -c  string  YAML or JSON file of per-subnet scopes, relay rules and client classes\n"
+	usage = usage + "              (override CONFIG_FILE env var)\n"
+	usage = usage + "  -dns        comma separated DNS server addresses sent in option 6, none if empty\n"
+	usage = usage + "              (override DNS_SERVERS env var)\n"
+	usage = usage + "  -router     default gateway address sent in option 3, none if empty\n"
+	usage = usage + "              (override ROUTER env var)\n"
+	usage = usage + "  -ntp        comma separated NTP server addresses sent in option 42, none if empty\n"
+	usage = usage + "              (override NTP_SERVERS env var)\n"
+	usage = usage + "  -search     comma separated domain search list sent in option 119, none if empty\n"
+	usage = usage + "              (override DOMAIN_SEARCH env var)\n"
+	usage = usage + "  -rps string URL of the RPS REST API to fetch the domains from, authenticated with the\n"
+	usage = usage + "              RPS_TOKEN env var (override RPS_URL env var)\n"
+	usage = usage + "  -mps string URL of the MPS REST API to fetch the devices activated by RPS from, with\n"
+	usage = usage + "              the same token (override MPS_URL env var)\n"
+	usage = usage + "  -cert       PEM or PKCS#12 AMT provisioning certificate the dns suffixes are checked\n"
+	usage = usage + "              against, the password in the PROVISIONING_CERT_PASSWORD env var\n"
+	usage = usage + "              (override PROVISIONING_CERT env var)\n"
+	usage = usage + "  -strict     refuse dns suffixes the provisioning certificates of -cert and RPS do not\n"
+	usage = usage + "              cover instead of logging a warning (override STRICT_SUFFIX env var)\n"
+	usage = usage + "  -i  string  comma separated network interfaces to serve on by name, index or CIDR, the\n"
+	usage = usage + "              default route interface if empty (override INTERFACE env var)\n"
+	usage = usage + "  -l  string  JSON file leases are kept in across restarts, memory only if empty\n"
+	usage = usage + "              (override LEASE_FILE env var)\n"
+	usage = usage + "  -a  string  address pool to offer from as start-end, e.g. 10.0.0.100-10.0.0.200\n"
+	usage = usage + "              (override POOL_RANGE env var)\n"
+	usage = usage + "  -x  string  comma separated addresses or ranges excluded from the pool\n"
+	usage = usage + "              (override POOL_EXCLUDE env var)\n"
+	usage = usage + "  -probe      ping pool addresses before offering them (override PROBE env var)\n"
+	usage = usage + "  -proxy      leave addressing to the site DHCP server and only add the dns suffix to the\n"
+	usage = usage + "              leases it grants (override PROXY env var)\n"
+	usage = usage + "  -raw        build Ethernet frames on a raw socket so replies reach clients without an\n"
+	usage = usage + "              address by their MAC, needs CAP_NET_RAW (override RAW_SOCKET env var)\n"
+	usage = usage + "  -amt        only answer Intel AMT clients, unless -c defines classes\n"
+	usage = usage + "              (override AMT_ONLY env var)\n"
+	usage = usage + "  -b          broadcast to 255.255.255.255 instead of the subnet broadcast address\n"
+	usage = usage + "              (override LIMITED_BROADCAST env var)\n\n"
 	usage = usage + "              Example: rpe.exe -p 8005 -d demo.com\n\n"
 
 	return usage
@@ -65,3 +171,15 @@ func LookupEnvOrInt(key string, defaultVal int) int {
 	}
 	return defaultVal
 }
+
+func LookupEnvOrBool(key string, defaultVal bool) bool {
+	if val, ok := os.LookupEnv(key); ok {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Println(err.Error())
+			return defaultVal
+		}
+		return v
+	}
+	return defaultVal
+}
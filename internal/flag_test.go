@@ -7,6 +7,7 @@ package rpe
 import (
 	"flag"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,11 +23,21 @@ func TestNewFlagsWithEnv(t *testing.T) {
 	setupTest()
 	os.Setenv("PORT", "1234")
 	os.Setenv("DNS_SUFFIX", "testDemo")
+	os.Setenv("RPS_TOKEN", "secret")
+	os.Setenv("PROVISIONING_CERT_PASSWORD", "P@ssw0rd")
+	os.Setenv("API_TOKEN", "apisecret")
 	flags := NewFlags()
 	assert.Equal(t, "testDemo", flags.DNSSuffix)
 	assert.Equal(t, 1234, flags.Port)
+	assert.Equal(t, "secret", flags.RPSToken)
+	assert.Equal(t, "P@ssw0rd", flags.CertPassword)
+	assert.Equal(t, "apisecret", flags.APIToken)
+	assert.Equal(t, "127.0.0.1", flags.APIHost)
 	os.Setenv("PORT", "")
 	os.Setenv("DNS_SUFFIX", "")
+	os.Setenv("RPS_TOKEN", "")
+	os.Setenv("PROVISIONING_CERT_PASSWORD", "")
+	os.Setenv("API_TOKEN", "")
 }
 
 func TestNewFlagsWithArgs(t *testing.T) {
@@ -57,6 +68,92 @@ func TestLookupEnvOrIntError(t *testing.T) {
 	os.Setenv("PORT", "")
 }
 
+func TestLookupEnvOrBool(t *testing.T) {
+	os.Setenv("LIMITED_BROADCAST", "true")
+	actual := LookupEnvOrBool("LIMITED_BROADCAST", false)
+	assert.True(t, actual)
+	os.Setenv("LIMITED_BROADCAST", "")
+}
+
+func TestLookupEnvOrBoolError(t *testing.T) {
+	os.Setenv("LIMITED_BROADCAST", "yes please")
+	actual := LookupEnvOrBool("LIMITED_BROADCAST", false)
+	assert.False(t, actual)
+	os.Setenv("LIMITED_BROADCAST", "")
+}
+
+func TestParseFlagsLimitedBroadcast(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-b"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.True(t, flags.LimitedBroadcast)
+}
+
+func TestParseFlagsPool(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-a", "10.0.0.100-10.0.0.200", "-x", "10.0.0.150", "-probe"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.100-10.0.0.200", flags.PoolRange)
+	assert.Equal(t, "10.0.0.150", flags.PoolExclude)
+	assert.True(t, flags.Probe)
+}
+
+func TestParseFlagsProxy(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-proxy", "-raw", "-amt"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.True(t, flags.Proxy)
+	assert.True(t, flags.RawSocket)
+	assert.True(t, flags.AMTOnly)
+}
+
+func TestParseFlagsServers(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-dns", "10.0.0.53, 10.0.1.53", "-router", "10.0.0.1", "-ntp", "10.0.0.123"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.53, 10.0.1.53", flags.DNSServers)
+	assert.Equal(t, "10.0.0.1", flags.Router)
+	assert.Equal(t, "10.0.0.123", flags.NTPServers)
+}
+
+func TestParseFlagsServersInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-dns", "8.8.8"},
+		{"-router", "10.0.0.1,10.0.0.2"},
+		{"-ntp", "fd00::123"},
+	} {
+		setupTest()
+		os.Args = append([]string{"./rpe", "-d", "testDemo"}, args...)
+		flags := NewFlags()
+		assert.Error(t, flags.ParseFlags(), args[0])
+	}
+}
+
+func TestParseFlagsCert(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-cert", "provisioning.pfx", "-strict"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, "provisioning.pfx", flags.CertFile)
+	assert.True(t, flags.StrictSuffix)
+
+	// the certificates may come from RPS
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-strict"}
+	flags = NewFlags()
+	assert.NoError(t, flags.ParseFlags())
+	assert.True(t, flags.StrictSuffix)
+}
+
 func TestParseFlags(t *testing.T) {
 	setupTest()
 	os.Args = []string{"./rpe", "-d", "testDemo", "-p", "1234"}
@@ -66,6 +163,54 @@ func TestParseFlags(t *testing.T) {
 	assert.Equal(t, "testDemo", flags.DNSSuffix)
 	assert.Equal(t, 1234, flags.Port)
 }
+
+func TestParseFlagsFiles(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-r", "reservations.yaml", "-i", "enp3s0", "-l", "leases.json", "-c", "rpe.yaml", "-rps", "https://rps.example.com", "-mps", "https://mps.example.com"}
+	flags := NewFlags()
+	err := flags.ParseFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, "reservations.yaml", flags.ReservationsFile)
+	assert.Equal(t, "enp3s0", flags.Interface)
+	assert.Equal(t, "leases.json", flags.LeaseFile)
+	assert.Equal(t, "rpe.yaml", flags.ConfigFile)
+	assert.Equal(t, "https://rps.example.com", flags.RPSURL)
+	assert.Equal(t, "https://mps.example.com", flags.MPSURL)
+
+	// devices are matched to the domains, one is no use without the other
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-rps", "https://rps.example.com"}
+	flags = NewFlags()
+	assert.Error(t, flags.ParseFlags())
+}
+func TestParseFlagsInvalidDNS(t *testing.T) {
+	for _, suffix := range []string{"my site.com", "a..com", strings.Repeat("a", 64) + ".com"} {
+		setupTest()
+		os.Args = []string{"./rpe", "-d", suffix}
+		flags := NewFlags()
+		assert.Error(t, flags.ParseFlags(), suffix)
+	}
+
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "bücher.example."}
+	flags := NewFlags()
+	assert.NoError(t, flags.ParseFlags())
+	assert.Equal(t, "xn--bcher-kva.example", flags.DNSSuffix)
+}
+
+func TestParseFlagsDomainSearch(t *testing.T) {
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-search", "site1.example.com, example.com"}
+	flags := NewFlags()
+	assert.NoError(t, flags.ParseFlags())
+	assert.Equal(t, "site1.example.com, example.com", flags.DomainSearch)
+
+	setupTest()
+	os.Args = []string{"./rpe", "-d", "testDemo", "-search", "example.com,my site.com"}
+	flags = NewFlags()
+	assert.Error(t, flags.ParseFlags())
+}
+
 func TestParseFlagsMissingDNS(t *testing.T) {
 	setupTest()
 	os.Args = []string{"./rpe", "-p", "1234"}
@@ -83,7 +228,46 @@ func TestUsage(t *testing.T) {
 	expected = expected + "Usage: rpe [OPTIONS]\n\n"
 	expected = expected + "OPTIONS:\n"
 	expected = expected + "  -p  int     port to listen on (override PORT env var)\n"
-	expected = expected + "  -d  string  dns suffix to broadcast in option 15 of DHCP (override DNS_SUFFIX env var)\n\n"
+	expected = expected + "  -host       address the control API listens on, 127.0.0.1 by default, requests need\n"
+	expected = expected + "              the API_TOKEN env var as bearer token if set (override API_HOST env var)\n"
+	expected = expected + "  -d  string  dns suffix to broadcast in option 15 of DHCP (override DNS_SUFFIX env var)\n"
+	expected = expected + "  -r  string  YAML or JSON file of per-device reservations (override RESERVATIONS_FILE env var)\n"
+	expected = expected + "  -c  string  YAML or JSON file of per-subnet scopes, relay rules and client classes\n"
+	expected = expected + "              (override CONFIG_FILE env var)\n"
+	expected = expected + "  -dns        comma separated DNS server addresses sent in option 6, none if empty\n"
+	expected = expected + "              (override DNS_SERVERS env var)\n"
+	expected = expected + "  -router     default gateway address sent in option 3, none if empty\n"
+	expected = expected + "              (override ROUTER env var)\n"
+	expected = expected + "  -ntp        comma separated NTP server addresses sent in option 42, none if empty\n"
+	expected = expected + "              (override NTP_SERVERS env var)\n"
+	expected = expected + "  -search     comma separated domain search list sent in option 119, none if empty\n"
+	expected = expected + "              (override DOMAIN_SEARCH env var)\n"
+	expected = expected + "  -rps string URL of the RPS REST API to fetch the domains from, authenticated with the\n"
+	expected = expected + "              RPS_TOKEN env var (override RPS_URL env var)\n"
+	expected = expected + "  -mps string URL of the MPS REST API to fetch the devices activated by RPS from, with\n"
+	expected = expected + "              the same token (override MPS_URL env var)\n"
+	expected = expected + "  -cert       PEM or PKCS#12 AMT provisioning certificate the dns suffixes are checked\n"
+	expected = expected + "              against, the password in the PROVISIONING_CERT_PASSWORD env var\n"
+	expected = expected + "              (override PROVISIONING_CERT env var)\n"
+	expected = expected + "  -strict     refuse dns suffixes the provisioning certificates of -cert and RPS do not\n"
+	expected = expected + "              cover instead of logging a warning (override STRICT_SUFFIX env var)\n"
+	expected = expected + "  -i  string  comma separated network interfaces to serve on by name, index or CIDR, the\n"
+	expected = expected + "              default route interface if empty (override INTERFACE env var)\n"
+	expected = expected + "  -l  string  JSON file leases are kept in across restarts, memory only if empty\n"
+	expected = expected + "              (override LEASE_FILE env var)\n"
+	expected = expected + "  -a  string  address pool to offer from as start-end, e.g. 10.0.0.100-10.0.0.200\n"
+	expected = expected + "              (override POOL_RANGE env var)\n"
+	expected = expected + "  -x  string  comma separated addresses or ranges excluded from the pool\n"
+	expected = expected + "              (override POOL_EXCLUDE env var)\n"
+	expected = expected + "  -probe      ping pool addresses before offering them (override PROBE env var)\n"
+	expected = expected + "  -proxy      leave addressing to the site DHCP server and only add the dns suffix to the\n"
+	expected = expected + "              leases it grants (override PROXY env var)\n"
+	expected = expected + "  -raw        build Ethernet frames on a raw socket so replies reach clients without an\n"
+	expected = expected + "              address by their MAC, needs CAP_NET_RAW (override RAW_SOCKET env var)\n"
+	expected = expected + "  -amt        only answer Intel AMT clients, unless -c defines classes\n"
+	expected = expected + "              (override AMT_ONLY env var)\n"
+	expected = expected + "  -b          broadcast to 255.255.255.255 instead of the subnet broadcast address\n"
+	expected = expected + "              (override LIMITED_BROADCAST env var)\n\n"
 	expected = expected + "              Example: rpe.exe -p 8005 -d demo.com\n\n"
 
 	assert.Equal(t, expected, result)
@@ -0,0 +1,130 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Flags of the client FQDN option, RFC 4702 section 2.1
+const (
+	fqdnServerUpdate = 0x01 // S: the server updates the A record
+	fqdnOverride     = 0x02 // O: the server overrode the S the client sent
+	fqdnEncoded      = 0x04 // E: the name is in DNS wire format
+	fqdnNoUpdate     = 0x08 // N: the server updates no records
+)
+
+// ClientFQDN is the content of option 81, the name a client wants to be
+// known by and who is to register it in DNS.
+type ClientFQDN struct {
+	Flags   byte
+	Name    string // dotted, empty to leave the choice to the server
+	Partial bool   // Name is a host label or partial name, not fully qualified
+}
+
+// ParseClientFQDN decodes option 81 as sent by a client, in either the wire
+// or the deprecated ASCII encoding.
+func ParseClientFQDN(value []byte) (ClientFQDN, error) {
+	if len(value) < 3 {
+		return ClientFQDN{}, errors.New("client fqdn option is too short")
+	}
+	fqdn := ClientFQDN{Flags: value[0]}
+	name := value[3:]
+	if fqdn.Flags&fqdnEncoded == 0 {
+		fqdn.Name = strings.TrimSuffix(string(name), ".")
+		fqdn.Partial = fqdn.Name != "" && !strings.HasSuffix(string(name), ".")
+		return fqdn, nil
+	}
+	// A partial name lacks the root label, compression is not allowed
+	var labels []string
+	fqdn.Partial = len(name) > 0
+	for i := 0; i < len(name); {
+		length := int(name[i])
+		if length == 0 {
+			if i != len(name)-1 {
+				return ClientFQDN{}, errors.New("client fqdn continues past the root label")
+			}
+			fqdn.Partial = false
+			break
+		}
+		if length > maxLabelLen || i+1+length > len(name) {
+			return ClientFQDN{}, fmt.Errorf("client fqdn has an invalid label length %d", length)
+		}
+		labels = append(labels, string(name[i+1:i+1+length]))
+		i += 1 + length
+	}
+	fqdn.Name = strings.Join(labels, ".")
+	return fqdn, nil
+}
+
+// wireName encodes the name in the encoding the E flag selects
+func (f ClientFQDN) wireName() []byte {
+	if f.Flags&fqdnEncoded == 0 {
+		if f.Name != "" && !f.Partial {
+			return []byte(f.Name + ".")
+		}
+		return []byte(f.Name)
+	}
+	var b []byte
+	if f.Name != "" {
+		for _, label := range strings.Split(f.Name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	if !f.Partial {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// clientHostname returns the host name a client sent in option 12, or else
+// the first label of the name in its option 81
+func clientHostname(options Options) string {
+	if hostname := options[OptionHostName]; len(hostname) > 0 {
+		return string(hostname)
+	}
+	fqdn, err := ParseClientFQDN(options[OptionClientFQDN])
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(fqdn.Name, ".", 2)[0]
+}
+
+// fqdnReply answers the client FQDN option of a request, RFC 4702 section
+// 4.  RPE updates no DNS records, so the reply sets N, overriding a client
+// that asked the server to update, and names the client by its host name
+// under the suffix sent in option 15, which is what it should register.
+func fqdnReply(reqOptions Options, replyOptions []Option) (Option, bool) {
+	value, ok := reqOptions[OptionClientFQDN]
+	if !ok {
+		return Option{}, false
+	}
+	fqdn, err := ParseClientFQDN(value)
+	if err != nil {
+		log.Println("Ignoring client FQDN: ", err)
+		return Option{}, false
+	}
+	reply := ClientFQDN{Flags: fqdn.Flags&fqdnEncoded | fqdnNoUpdate}
+	if fqdn.Flags&fqdnServerUpdate != 0 {
+		reply.Flags |= fqdnOverride
+	}
+	if host := clientHostname(reqOptions); checkLabel(host) == nil {
+		reply.Name, reply.Partial = host, true
+		if suffix := optionValue(replyOptions, OptionDomainName); suffix != nil {
+			if name, err := NormalizeDomain(host + "." + string(suffix)); err == nil {
+				reply.Name, reply.Partial = name, false
+			}
+		}
+	}
+	opt, err := OptionFQDN(OptionClientFQDN, reply)
+	if err != nil {
+		return Option{}, false
+	}
+	return opt, true
+}
@@ -0,0 +1,95 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClientFQDN(t *testing.T) {
+	for value, expected := range map[string]ClientFQDN{
+		"\x05\x00\x00\x04vpro\x07example\x03com\x00": {Flags: fqdnServerUpdate | fqdnEncoded, Name: "vpro.example.com"},
+		"\x04\x00\x00\x04vpro":                       {Flags: fqdnEncoded, Name: "vpro", Partial: true},
+		"\x04\x00\x00":                               {Flags: fqdnEncoded},
+		"\x00\x00\x00vpro.example.com.":              {Name: "vpro.example.com"},
+		"\x01\xff\xffvpro":                           {Flags: fqdnServerUpdate, Name: "vpro", Partial: true},
+	} {
+		fqdn, err := ParseClientFQDN([]byte(value))
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, fqdn, value)
+	}
+}
+
+func TestParseClientFQDNInvalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"\x04\x00",
+		"\x04\x00\x00\x04vp",
+		"\x04\x00\x00\x04vpro\x00\x03com",
+		"\x04\x00\x00\xc0\x00",
+	} {
+		_, err := ParseClientFQDN([]byte(value))
+		assert.Error(t, err, value)
+	}
+}
+
+func TestOptionFQDN(t *testing.T) {
+	opt, err := OptionFQDN(OptionClientFQDN, ClientFQDN{Flags: fqdnEncoded | fqdnNoUpdate, Name: "vpro.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x0c\xff\xff\x04vpro\x07example\x03com\x00"), opt.Value)
+
+	opt, _ = OptionFQDN(OptionClientFQDN, ClientFQDN{Flags: fqdnNoUpdate, Name: "vpro", Partial: true})
+	assert.Equal(t, []byte("\x08\xff\xffvpro"), opt.Value)
+
+	_, err = OptionFQDN(OptionClientFQDN, ClientFQDN{Name: "my vpro"})
+	assert.Error(t, err)
+}
+
+func TestFQDNReply(t *testing.T) {
+	suffix := []Option{{Code: OptionDomainName, Value: []byte("site1.example.com")}}
+
+	// the client asks the server to update, RPE does not
+	opt, ok := fqdnReply(Options{OptionClientFQDN: []byte("\x05\x00\x00\x04vpro\x07example\x03org\x00")}, suffix)
+	assert.True(t, ok)
+	fqdn, _ := ParseClientFQDN(opt.Value)
+	assert.Equal(t, ClientFQDN{Flags: fqdnEncoded | fqdnOverride | fqdnNoUpdate, Name: "vpro.site1.example.com"}, fqdn)
+
+	// no name of its own, the host name option names it
+	opt, ok = fqdnReply(Options{OptionClientFQDN: []byte("\x00\x00\x00"), OptionHostName: []byte("nuc7")}, suffix)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("\x08\xff\xffnuc7.site1.example.com."), opt.Value)
+
+	// without a suffix the name stays partial
+	opt, _ = fqdnReply(Options{OptionClientFQDN: []byte("\x04\x00\x00\x04vpro")}, nil)
+	assert.Equal(t, []byte("\x0c\xff\xff\x04vpro"), opt.Value)
+
+	_, ok = fqdnReply(Options{}, suffix)
+	assert.False(t, ok)
+	_, ok = fqdnReply(Options{OptionClientFQDN: []byte{4}}, suffix)
+	assert.False(t, ok)
+}
+
+func TestHandleRequestClientFQDN(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestSelectingRequest(serverIP, "10.20.30.131")
+	req.AddOption(OptionClientFQDN, []byte("\x04\x00\x00\x04vpro"))
+
+	ack, err := s.handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	options, _ := ack.Options()
+	assert.Equal(t, []byte("\x0c\xff\xff\x04vpro\x04test\x03com\x00"), options[OptionClientFQDN])
+	lease, _ := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.Equal(t, "vpro", lease.Hostname)
+
+	// clients that do not send option 81 do not get it
+	ack, _ = s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+	options, _ = ack.Options()
+	assert.Nil(t, options[OptionClientFQDN])
+}
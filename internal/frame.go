@@ -0,0 +1,181 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	etherTypeIPv4  = 0x0800
+	etherHeaderLen = 14
+	ipv4HeaderLen  = 20
+	udpHeaderLen   = 8
+	protocolUDP    = 17
+)
+
+var (
+	ErrFrameTruncated = errors.New("frame truncated")
+	ErrNotUDP         = errors.New("frame is not an unfragmented IPv4 UDP datagram")
+	ErrIPChecksum     = errors.New("invalid IPv4 header checksum")
+)
+
+// udpFrame is an Ethernet frame carrying a UDP datagram over IPv4
+type udpFrame struct {
+	SrcMAC  net.HardwareAddr
+	DstMAC  net.HardwareAddr
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+	Payload []byte
+}
+
+// marshal builds the frame with its IPv4 header and UDP checksums
+func (f udpFrame) marshal() ([]byte, error) {
+	srcIP, dstIP := f.SrcIP.To4(), f.DstIP.To4()
+	if srcIP == nil || dstIP == nil {
+		return nil, errors.New("frame addresses must be IPv4")
+	}
+	if len(f.SrcMAC) != 6 || len(f.DstMAC) != 6 {
+		return nil, errors.New("frame hardware addresses must be Ethernet")
+	}
+	udpLen := udpHeaderLen + len(f.Payload)
+	if ipv4HeaderLen+udpLen > 0xffff {
+		return nil, errors.New("frame payload too large")
+	}
+	frame := make([]byte, etherHeaderLen+ipv4HeaderLen+udpLen)
+
+	copy(frame[0:6], f.DstMAC)
+	copy(frame[6:12], f.SrcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+
+	ip := frame[etherHeaderLen : etherHeaderLen+ipv4HeaderLen]
+	ip[0] = 0x45 // version 4, 5 word header
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+udpLen))
+	ip[8] = 64 // TTL
+	ip[9] = protocolUDP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip))
+
+	udp := frame[etherHeaderLen+ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:2], f.SrcPort)
+	binary.BigEndian.PutUint16(udp[2:4], f.DstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	copy(udp[udpHeaderLen:], f.Payload)
+	sum := checksum(append(udpPseudoHeader(srcIP, dstIP, udpLen), udp...))
+	if sum == 0 {
+		// zero means no checksum was computed
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], sum)
+	return frame, nil
+}
+
+// udpPseudoHeader returns the IPv4 pseudo header the UDP checksum covers
+func udpPseudoHeader(srcIP net.IP, dstIP net.IP, udpLen int) []byte {
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], srcIP)
+	copy(pseudo[4:8], dstIP)
+	pseudo[9] = protocolUDP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(udpLen))
+	return pseudo
+}
+
+// parseUDPFrame decodes an Ethernet frame carrying a UDP datagram over IPv4.
+// The payload aliases b.  UDP checksums are not verified as frames sent by
+// this host may still be waiting for the NIC to fill them in.
+func parseUDPFrame(b []byte) (udpFrame, error) {
+	if len(b) < etherHeaderLen+ipv4HeaderLen {
+		return udpFrame{}, ErrFrameTruncated
+	}
+	if binary.BigEndian.Uint16(b[12:14]) != etherTypeIPv4 {
+		return udpFrame{}, ErrNotUDP
+	}
+	ip := b[etherHeaderLen:]
+	headerLen := int(ip[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(ip[2:4]))
+	if ip[0]>>4 != 4 || headerLen < ipv4HeaderLen || ip[9] != protocolUDP {
+		return udpFrame{}, ErrNotUDP
+	}
+	// more fragments flag or a fragment offset
+	if binary.BigEndian.Uint16(ip[6:8])&0x3fff != 0 {
+		return udpFrame{}, ErrNotUDP
+	}
+	if totalLen > len(ip) || totalLen < headerLen+udpHeaderLen {
+		return udpFrame{}, ErrFrameTruncated
+	}
+	if checksum(ip[:headerLen]) != 0 {
+		return udpFrame{}, ErrIPChecksum
+	}
+	udp := ip[headerLen:totalLen]
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < udpHeaderLen || udpLen > len(udp) {
+		return udpFrame{}, ErrFrameTruncated
+	}
+	return udpFrame{
+		DstMAC:  net.HardwareAddr(b[0:6]),
+		SrcMAC:  net.HardwareAddr(b[6:12]),
+		SrcIP:   net.IP(ip[12:16]),
+		DstIP:   net.IP(ip[16:20]),
+		SrcPort: binary.BigEndian.Uint16(udp[0:2]),
+		DstPort: binary.BigEndian.Uint16(udp[2:4]),
+		Payload: udp[udpHeaderLen:udpLen],
+	}, nil
+}
+
+// neighborTimeout is how long a learnt hardware address is used for
+const neighborTimeout = 5 * time.Minute
+
+// neighborCache maps the IPv4 addresses of relays to the hardware address
+// their frames arrived from, standing in for ARP on raw sockets.  Relays are
+// learnt under the source address and the giaddr of the requests they
+// forward, as they may send them from another address.  Entries expire after
+// neighborTimeout, replies to relays follow their requests closely.
+type neighborCache struct {
+	mu      sync.Mutex
+	entries map[string]neighbor
+}
+
+type neighbor struct {
+	mac  net.HardwareAddr
+	seen time.Time
+}
+
+func (c *neighborCache) learn(f udpFrame) {
+	pkt := Packet(f.Payload)
+	if len(pkt) < 240 || pkt.OpCode() != bootRequest || pkt.GIAddr().Equal(net.IPv4zero) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]neighbor)
+	}
+	for ip, entry := range c.entries {
+		if now.Sub(entry.seen) > neighborTimeout {
+			delete(c.entries, ip)
+		}
+	}
+	entry := neighbor{mac: append(net.HardwareAddr(nil), f.SrcMAC...), seen: now}
+	c.entries[f.SrcIP.String()] = entry
+	c.entries[pkt.GIAddr().String()] = entry
+}
+
+func (c *neighborCache) lookup(ip net.IP) (net.HardwareAddr, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[ip.String()]
+	if !ok || time.Since(entry.seen) > neighborTimeout {
+		return nil, false
+	}
+	return entry.mac, true
+}
@@ -0,0 +1,138 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFrame() udpFrame {
+	srcMAC, _ := net.ParseMAC("00:aa:bb:cc:dd:ee")
+	dstMAC, _ := net.ParseMAC("00:11:22:33:44:55")
+	return udpFrame{
+		SrcMAC:  srcMAC,
+		DstMAC:  dstMAC,
+		SrcIP:   net.IPv4(10, 20, 30, 34),
+		DstIP:   net.IPv4(10, 20, 30, 131),
+		SrcPort: 67,
+		DstPort: 68,
+		Payload: []byte("hello"),
+	}
+}
+
+func TestUDPFrameMarshal(t *testing.T) {
+	frame, err := newTestFrame().marshal()
+
+	assert.NoError(t, err)
+	assert.Len(t, frame, etherHeaderLen+ipv4HeaderLen+udpHeaderLen+5)
+	assert.Equal(t, []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, frame[0:6])
+	assert.Equal(t, []byte{0x00, 0xaa, 0xbb, 0xcc, 0xdd, 0xee}, frame[6:12])
+	assert.Equal(t, []byte{0x08, 0x00}, frame[12:14])
+	ip := frame[etherHeaderLen : etherHeaderLen+ipv4HeaderLen]
+	assert.Equal(t, []byte{0x45, 0, 0, 33}, ip[0:4])
+	assert.Equal(t, byte(protocolUDP), ip[9])
+	assert.Equal(t, uint16(0), checksum(ip))
+	udp := frame[etherHeaderLen+ipv4HeaderLen:]
+	assert.Equal(t, []byte{0, 67, 0, 68, 0, 13}, udp[0:6])
+	pseudo := udpPseudoHeader(net.IPv4(10, 20, 30, 34).To4(), net.IPv4(10, 20, 30, 131).To4(), len(udp))
+	assert.Equal(t, uint16(0), checksum(append(pseudo, udp...)))
+}
+
+func TestUDPFrameMarshalErrors(t *testing.T) {
+	f := newTestFrame()
+	f.DstIP = net.ParseIP("fe80::1")
+	_, err := f.marshal()
+	assert.Error(t, err)
+
+	f = newTestFrame()
+	f.DstMAC = nil
+	_, err = f.marshal()
+	assert.Error(t, err)
+
+	f = newTestFrame()
+	f.Payload = make([]byte, 0x10000)
+	_, err = f.marshal()
+	assert.Error(t, err)
+}
+
+func TestParseUDPFrame(t *testing.T) {
+	b, _ := newTestFrame().marshal()
+	// Ethernet pads short frames
+	b = append(b, make([]byte, 10)...)
+
+	f, err := parseUDPFrame(b)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "00:aa:bb:cc:dd:ee", f.SrcMAC.String())
+	assert.Equal(t, "00:11:22:33:44:55", f.DstMAC.String())
+	assert.Equal(t, "10.20.30.34", f.SrcIP.String())
+	assert.Equal(t, "10.20.30.131", f.DstIP.String())
+	assert.Equal(t, uint16(67), f.SrcPort)
+	assert.Equal(t, uint16(68), f.DstPort)
+	assert.Equal(t, []byte("hello"), f.Payload)
+}
+
+func TestParseUDPFrameErrors(t *testing.T) {
+	valid, _ := newTestFrame().marshal()
+	corrupt := func(modify func(b []byte) []byte) []byte {
+		b := append([]byte(nil), valid...)
+		return modify(b)
+	}
+
+	tests := []struct {
+		frame []byte
+		err   error
+	}{
+		{valid[:20], ErrFrameTruncated},
+		{corrupt(func(b []byte) []byte { b[12] = 0x86; b[13] = 0xdd; return b }), ErrNotUDP},
+		{corrupt(func(b []byte) []byte { b[etherHeaderLen+9] = 6; return b }), ErrNotUDP},
+		{corrupt(func(b []byte) []byte { b[etherHeaderLen+6] = 0x20; return b }), ErrNotUDP},
+		{corrupt(func(b []byte) []byte { b[etherHeaderLen+8] = 1; return b }), ErrIPChecksum},
+		{valid[:len(valid)-2], ErrFrameTruncated},
+	}
+	for _, test := range tests {
+		_, err := parseUDPFrame(test.frame)
+		assert.Equal(t, test.err, err)
+	}
+}
+
+func TestNeighborCache(t *testing.T) {
+	var cache neighborCache
+	_, ok := cache.lookup(net.IPv4(10, 20, 30, 1))
+	assert.False(t, ok)
+
+	relayed := newTestRequest(dhcpDiscover)
+	relayed.SetGIAddr(net.ParseIP("10.50.0.1"))
+	f := newTestFrame()
+	f.SrcIP = net.IPv4(10, 20, 30, 1)
+	f.Payload = relayed
+	cache.learn(f)
+
+	for _, ip := range []net.IP{net.IPv4(10, 20, 30, 1), net.IPv4(10, 50, 0, 1)} {
+		mac, ok := cache.lookup(ip)
+		assert.True(t, ok)
+		assert.Equal(t, "00:aa:bb:cc:dd:ee", mac.String())
+	}
+	_, ok = cache.lookup(net.IPv4(10, 20, 30, 131))
+	assert.False(t, ok)
+
+	// clients are reached by chaddr, only relays are learnt
+	f.SrcIP = net.IPv4(10, 20, 30, 2)
+	f.Payload = newTestRequest(dhcpDiscover)
+	cache.learn(f)
+	_, ok = cache.lookup(net.IPv4(10, 20, 30, 2))
+	assert.False(t, ok)
+
+	for ip, entry := range cache.entries {
+		entry.seen = time.Now().Add(-2 * neighborTimeout)
+		cache.entries[ip] = entry
+	}
+	_, ok = cache.lookup(net.IPv4(10, 50, 0, 1))
+	assert.False(t, ok)
+}
@@ -0,0 +1,126 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// hubQueueLen is how many datagrams an endpoint holds before further ones
+// are dropped, as a congested network would
+const hubQueueLen = 64
+
+// Hub is an in-memory Ethernet segment.  Transports attached with Listen
+// exchange datagrams without sockets, so clients and servers can be wired
+// together in tests without root or a NIC.
+type Hub struct {
+	mu        sync.Mutex
+	subnet    *net.IPNet
+	endpoints map[*hubTransport]bool
+}
+
+type hubTransport struct {
+	hub     *Hub
+	iface   string
+	addr    *net.UDPAddr
+	packets chan hubPacket
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type hubPacket struct {
+	data []byte
+	from Peer
+}
+
+// NewHub returns a segment whose directed broadcast address is that of
+// subnet
+func NewHub(subnet *net.IPNet) *Hub {
+	return &Hub{subnet: subnet, endpoints: make(map[*hubTransport]bool)}
+}
+
+// Listen attaches a Transport bound to addr.  Endpoints bound to the
+// unspecified address receive everything sent to their port, as a client
+// without an address listening on a raw socket would.  Listen has the
+// signature of a ListenFunc so a Hub can stand in for the network of a
+// Server.
+func (h *Hub) Listen(ctx context.Context, iface NetworkInterface, addr string) (Transport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udpAddr.IP == nil {
+		udpAddr.IP = net.IPv4zero
+	}
+	t := &hubTransport{
+		hub:     h,
+		iface:   iface.Name,
+		addr:    udpAddr,
+		packets: make(chan hubPacket, hubQueueLen),
+		closed:  make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.endpoints[t] = true
+	h.mu.Unlock()
+	return t, nil
+}
+
+// deliver queues data on every endpoint other than from that to addresses
+func (h *Hub) deliver(from *hubTransport, data []byte, to *net.UDPAddr) {
+	broadcast := to.IP.Equal(net.IPv4bcast) || (h.subnet != nil && to.IP.Equal(directedBroadcast(h.subnet)))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for t := range h.endpoints {
+		if t == from || t.addr.Port != to.Port {
+			continue
+		}
+		if !broadcast && !t.addr.IP.Equal(net.IPv4zero) && !t.addr.IP.Equal(to.IP) {
+			continue
+		}
+		packet := hubPacket{
+			data: append([]byte(nil), data...),
+			from: Peer{Interface: t.iface, Addr: from.addr},
+		}
+		select {
+		case t.packets <- packet:
+		default:
+		}
+	}
+}
+
+func (t *hubTransport) ReadFrom(b []byte) (int, Peer, error) {
+	select {
+	case packet := <-t.packets:
+		return copy(b, packet.data), packet.from, nil
+	case <-t.closed:
+		return 0, Peer{}, net.ErrClosed
+	}
+}
+
+func (t *hubTransport) WriteTo(b []byte, peer Peer) (int, error) {
+	select {
+	case <-t.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if peer.Addr == nil {
+		return 0, errors.New("peer has no address")
+	}
+	t.hub.deliver(t, b, peer.Addr)
+	return len(b), nil
+}
+
+func (t *hubTransport) Close() error {
+	t.once.Do(func() {
+		t.hub.mu.Lock()
+		delete(t.hub.endpoints, t)
+		t.hub.mu.Unlock()
+		close(t.closed)
+	})
+	return nil
+}
@@ -0,0 +1,246 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHub() *Hub {
+	_, subnet, _ := net.ParseCIDR("10.20.30.0/24")
+	return NewHub(subnet)
+}
+
+func listenTestHub(t *testing.T, hub *Hub, name string, addr string) Transport {
+	conn, err := hub.Listen(context.Background(), NetworkInterface{Name: name}, addr)
+	assert.NoError(t, err)
+	return conn
+}
+
+// readTestHub returns the next datagram conn receives, failing the test
+// when nothing arrives
+func readTestHub(t *testing.T, conn Transport) ([]byte, Peer) {
+	type result struct {
+		data []byte
+		peer Peer
+	}
+	results := make(chan result, 1)
+	go func() {
+		buffer := make([]byte, 1500)
+		n, peer, err := conn.ReadFrom(buffer)
+		if err == nil {
+			results <- result{buffer[:n], peer}
+		}
+	}()
+	select {
+	case r := <-results:
+		return r.data, r.peer
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+	return nil, Peer{}
+}
+
+// assertNothingQueued checks that no datagram is waiting on conn
+func assertNothingQueued(t *testing.T, conn Transport) {
+	assert.Len(t, conn.(*hubTransport).packets, 0)
+}
+
+func TestHubDelivery(t *testing.T) {
+	hub := newTestHub()
+	server := listenTestHub(t, hub, "eth0", "10.20.30.34:67")
+	other := listenTestHub(t, hub, "eth0", "10.20.30.35:67")
+	client := listenTestHub(t, hub, "client", "0.0.0.0:68")
+	bound := listenTestHub(t, hub, "client", "10.20.30.99:68")
+
+	// unicast reaches the address and anyone listening on any address
+	_, err := server.WriteTo([]byte("unicast"), Peer{Addr: &net.UDPAddr{IP: net.IPv4(10, 20, 30, 131), Port: 68}})
+	assert.NoError(t, err)
+	data, peer := readTestHub(t, client)
+	assert.Equal(t, "unicast", string(data))
+	assert.Equal(t, "client", peer.Interface)
+	assert.Equal(t, "10.20.30.34:67", peer.Addr.String())
+	assertNothingQueued(t, bound)
+
+	// both broadcast addresses reach everyone on the port but the sender
+	for _, ip := range []net.IP{net.IPv4bcast, net.IPv4(10, 20, 30, 255)} {
+		_, err = client.WriteTo([]byte("broadcast"), Peer{Addr: &net.UDPAddr{IP: ip, Port: 67}})
+		assert.NoError(t, err)
+		data, _ = readTestHub(t, server)
+		assert.Equal(t, "broadcast", string(data))
+		data, _ = readTestHub(t, other)
+		assert.Equal(t, "broadcast", string(data))
+		assertNothingQueued(t, client)
+		assertNothingQueued(t, bound)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := newTestHub()
+	conn := listenTestHub(t, hub, "eth0", ":67")
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 1500))
+		errs <- err
+	}()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, conn.Close())
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read not interrupted by close")
+	}
+	_, err := conn.WriteTo([]byte("offer"), Peer{Addr: &net.UDPAddr{IP: net.IPv4bcast, Port: 68}})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Empty(t, hub.endpoints)
+}
+
+func TestHubErrors(t *testing.T) {
+	hub := newTestHub()
+	_, err := hub.Listen(context.Background(), NetworkInterface{}, "256.0.0.1:67")
+	assert.Error(t, err)
+
+	conn := listenTestHub(t, hub, "eth0", ":67")
+	_, err = conn.WriteTo([]byte("offer"), Peer{})
+	assert.Error(t, err)
+}
+
+// runTestServer runs s on hub until the test ends
+func runTestServer(t *testing.T, s *Server, hub *Hub) {
+	s.cfg.Listen = hub.Listen
+	s.cfg.Enumerator = newMockEnumerator()
+	startTestServer(t, s)
+}
+
+// startTestServer runs s as configured until the test ends
+func startTestServer(t *testing.T, s *Server) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-errs)
+	})
+	assert.Eventually(t, func() bool { return s.Status().Listening }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHubDHCPExchange(t *testing.T) {
+	hub := newTestHub()
+	s := newTestServer(t)
+	s.cfg.ListenAddr = ":67"
+	runTestServer(t, s, hub)
+	client := listenTestHub(t, hub, "client", ":68")
+	defer client.Close()
+	broadcast := Peer{Addr: &net.UDPAddr{IP: net.IPv4bcast, Port: 67}}
+
+	_, err := client.WriteTo(newTestRequest(dhcpDiscover), broadcast)
+	assert.NoError(t, err)
+	data, peer := readTestHub(t, client)
+	offer, err := ParsePacket(data)
+	assert.NoError(t, err)
+	options, _ := offer.Options()
+	assert.Equal(t, dhcpOffer, options.MessageType())
+	assert.Equal(t, "10.20.30.131", offer.YIAddr().String())
+	assert.Equal(t, 67, peer.Addr.Port)
+
+	serverIP := net.IP(options[OptionServerIdentifier])
+	_, err = client.WriteTo(newTestSelectingRequest(serverIP, "10.20.30.131"), broadcast)
+	assert.NoError(t, err)
+	data, _ = readTestHub(t, client)
+	ack, err := ParsePacket(data)
+	assert.NoError(t, err)
+	options, _ = ack.Options()
+	assert.Equal(t, dhcpAck, options.MessageType())
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+
+	assert.Len(t, s.Leases(), 1)
+	assert.Equal(t, uint64(1), s.Status().Offers)
+	assert.Equal(t, uint64(1), s.Status().Acks)
+}
+
+func TestHubProxyExchange(t *testing.T) {
+	hub := newTestHub()
+	s := newTestProxy(t)
+	s.snooper = NewSnooper(DefaultClientClasses())
+	runTestServer(t, s, hub)
+	site := listenTestHub(t, hub, "eth0", "10.20.30.1:67")
+	defer site.Close()
+	client := listenTestHub(t, hub, "client", ":68")
+	defer client.Close()
+
+	_, err := client.WriteTo(newTestAMTRequest(), Peer{Addr: &net.UDPAddr{IP: net.IPv4bcast, Port: 67}})
+	assert.NoError(t, err)
+	readTestHub(t, site)
+	assert.Eventually(t, func() bool { return len(s.Snooped()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = site.WriteTo(newTestSiteAck(), Peer{Addr: &net.UDPAddr{IP: net.IPv4bcast, Port: 68}})
+	assert.NoError(t, err)
+	data, _ := readTestHub(t, client)
+	siteAck, _ := ParsePacket(data)
+	options, _ := siteAck.Options()
+	assert.Nil(t, options[OptionDomainName])
+
+	data, peer := readTestHub(t, client)
+	injected, err := ParsePacket(data)
+	assert.NoError(t, err)
+	// injected from the server port, clients drop replies from others
+	assert.Equal(t, 67, peer.Addr.Port)
+	options, _ = injected.Options()
+	assert.Equal(t, dhcpAck, options.MessageType())
+	assert.Equal(t, []byte(siteServerIP), options[OptionServerIdentifier])
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+	assert.Equal(t, "10.20.30.77", injected.YIAddr().String())
+}
+
+func TestHubScopesPerInterface(t *testing.T) {
+	cfg, err := parseConfigFile([]byte(`
+scopes:
+  - cidr: 10.20.30.0/24
+    range: 10.20.30.100-10.20.30.200
+    domainName: site.example.com
+  - cidr: 192.168.0.0/16
+    range: 192.168.7.10-192.168.7.20
+    router: 192.168.5.254
+    domainName: lab.example.com
+`))
+	assert.NoError(t, err)
+	_, lab, _ := net.ParseCIDR("192.168.0.0/16")
+	hubs := map[string]*Hub{"enp3s0": newTestHub(), "br0": NewHub(lab)}
+	s := newTestServer(t)
+	s.cfg.ListenAddr = ":67"
+	s.cfg.Scopes = cfg.Scopes
+	s.cfg.Interface = "enp3s0,br0"
+	s.cfg.Enumerator = newTestEnumerator("")
+	s.cfg.Listen = func(ctx context.Context, iface NetworkInterface, addr string) (Transport, error) {
+		return hubs[iface.Name].Listen(ctx, iface, addr)
+	}
+	startTestServer(t, s)
+	assert.Equal(t, "enp3s0,br0", s.Status().Interface)
+
+	for name, expected := range map[string]struct{ ip, serverIP, domain string }{
+		"enp3s0": {"10.20.30.100", "10.20.30.34", "site.example.com"},
+		"br0":    {"192.168.7.10", "192.168.5.1", "lab.example.com"},
+	} {
+		client := listenTestHub(t, hubs[name], "client", ":68")
+		_, err := client.WriteTo(newTestRequest(dhcpDiscover), Peer{Addr: &net.UDPAddr{IP: net.IPv4bcast, Port: 67}})
+		assert.NoError(t, err)
+		data, _ := readTestHub(t, client)
+		client.Close()
+		offer, err := ParsePacket(data)
+		assert.NoError(t, err)
+		options, _ := offer.Options()
+		assert.Equal(t, expected.ip, offer.YIAddr().String(), name)
+		assert.Equal(t, expected.serverIP, net.IP(options[OptionServerIdentifier]).String(), name)
+		assert.Equal(t, []byte(expected.domain), options[OptionDomainName], name)
+	}
+}
@@ -0,0 +1,151 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lease records an address issued to a client
+type Lease struct {
+	MAC        string    `json:"mac"`
+	IP         net.IP    `json:"ip"`
+	Hostname   string    `json:"hostname,omitempty"`
+	DomainName string    `json:"domainName,omitempty"`
+	Expiry     time.Time `json:"expiry"`
+}
+
+// Expired reports whether the lease has run out at now
+func (l Lease) Expired(now time.Time) bool { return !now.Before(l.Expiry) }
+
+// LeaseStore keeps leases keyed by client MAC address.  Implementations must
+// be safe for concurrent use.
+type LeaseStore interface {
+	Get(mac string) (Lease, bool)
+	Put(lease Lease) error
+	Delete(mac string) error
+	List() []Lease
+}
+
+// MemoryLeaseStore keeps leases in memory only
+type MemoryLeaseStore struct {
+	mu     sync.RWMutex
+	leases map[string]Lease
+}
+
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{leases: make(map[string]Lease)}
+}
+
+func (m *MemoryLeaseStore) Get(mac string) (Lease, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	lease, ok := m.leases[mac]
+	return lease, ok
+}
+
+func (m *MemoryLeaseStore) Put(lease Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leases[lease.MAC] = lease
+	return nil
+}
+
+func (m *MemoryLeaseStore) Delete(mac string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.leases, mac)
+	return nil
+}
+
+// List returns all leases ordered by MAC address
+func (m *MemoryLeaseStore) List() []Lease {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	leases := make([]Lease, 0, len(m.leases))
+	for _, lease := range m.leases {
+		leases = append(leases, lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].MAC < leases[j].MAC })
+	return leases
+}
+
+// FileLeaseStore keeps leases in memory and writes them to a JSON file on
+// every change, so they survive restarts.
+type FileLeaseStore struct {
+	path string
+	mu   sync.Mutex // serialises writes to the file
+	mem  *MemoryLeaseStore
+}
+
+// NewFileLeaseStore loads the leases in path, if it exists, dropping those
+// that expired while RPE was not running.
+func NewFileLeaseStore(path string) (*FileLeaseStore, error) {
+	f := &FileLeaseStore{path: path, mem: NewMemoryLeaseStore()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []Lease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if !lease.Expired(now) {
+			f.mem.leases[lease.MAC] = lease
+		}
+	}
+	return f, nil
+}
+
+func (f *FileLeaseStore) Get(mac string) (Lease, bool) { return f.mem.Get(mac) }
+
+func (f *FileLeaseStore) List() []Lease { return f.mem.List() }
+
+func (f *FileLeaseStore) Put(lease Lease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mem.Put(lease)
+	return f.save()
+}
+
+func (f *FileLeaseStore) Delete(mac string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mem.Delete(mac)
+	return f.save()
+}
+
+// save replaces the file atomically so a crash never leaves it half written
+func (f *FileLeaseStore) save() error {
+	data, err := json.MarshalIndent(f.mem.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
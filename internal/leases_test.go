@@ -0,0 +1,91 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLease(mac string, ip string, expiry time.Time) Lease {
+	return Lease{MAC: mac, IP: net.ParseIP(ip).To4(), Hostname: "amt", DomainName: "test.com", Expiry: expiry}
+}
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+
+	assert.True(t, testLease("a", "10.0.0.1", now).Expired(now))
+	assert.False(t, testLease("a", "10.0.0.1", now.Add(time.Second)).Expired(now))
+}
+
+func TestMemoryLeaseStore(t *testing.T) {
+	store := NewMemoryLeaseStore()
+	expiry := time.Now().Add(time.Hour)
+
+	assert.NoError(t, store.Put(testLease("00:11:22:33:44:66", "10.0.0.2", expiry)))
+	assert.NoError(t, store.Put(testLease("00:11:22:33:44:55", "10.0.0.1", expiry)))
+
+	lease, ok := store.Get("00:11:22:33:44:55")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", lease.IP.String())
+	leases := store.List()
+	assert.Len(t, leases, 2)
+	assert.Equal(t, "00:11:22:33:44:55", leases[0].MAC)
+
+	assert.NoError(t, store.Delete("00:11:22:33:44:55"))
+	_, ok = store.Get("00:11:22:33:44:55")
+	assert.False(t, ok)
+}
+
+func TestFileLeaseStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	store, err := NewFileLeaseStore(path)
+	assert.NoError(t, err)
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+
+	assert.NoError(t, store.Put(testLease("00:11:22:33:44:55", "10.0.0.1", expiry)))
+	assert.NoError(t, store.Put(testLease("00:11:22:33:44:66", "10.0.0.2", expiry)))
+	assert.NoError(t, store.Delete("00:11:22:33:44:66"))
+
+	reopened, err := NewFileLeaseStore(path)
+	assert.NoError(t, err)
+	leases := reopened.List()
+	assert.Len(t, leases, 1)
+	assert.Equal(t, "10.0.0.1", leases[0].IP.String())
+	assert.Equal(t, "test.com", leases[0].DomainName)
+	assert.True(t, expiry.Equal(leases[0].Expiry))
+	lease, ok := reopened.Get("00:11:22:33:44:55")
+	assert.True(t, ok)
+	assert.Equal(t, "amt", lease.Hostname)
+}
+
+func TestFileLeaseStoreDropsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	store, _ := NewFileLeaseStore(path)
+	store.Put(testLease("00:11:22:33:44:55", "10.0.0.1", time.Now().Add(-time.Minute)))
+
+	reopened, err := NewFileLeaseStore(path)
+
+	assert.NoError(t, err)
+	assert.Len(t, reopened.List(), 0)
+}
+
+func TestFileLeaseStoreErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err := NewFileLeaseStore(path)
+	assert.Error(t, err)
+
+	_, err = NewFileLeaseStore(t.TempDir())
+	assert.Error(t, err)
+
+	store, _ := NewFileLeaseStore(filepath.Join(t.TempDir(), "missing", "leases.json"))
+	assert.Error(t, store.Put(testLease("00:11:22:33:44:55", "10.0.0.1", time.Now())))
+}
@@ -0,0 +1,128 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NetworkInterface is the interface RPE serves on and its IPv4 address
+type NetworkInterface struct {
+	Name    string
+	Index   int
+	Address *net.IPNet
+}
+
+// selectInterface picks the interface to serve on.  spec names it by name,
+// by index, or by a CIDR one of its IPv4 addresses falls in.  An empty spec
+// prefers the interface holding the default route, then the well known
+// wired interface names, then the first interface that is up.
+func selectInterface(ne NetworkEnumerator, spec string) (NetworkInterface, error) {
+	list, err := ne.Interfaces()
+	if err != nil {
+		return NetworkInterface{}, fmt.Errorf("failed getting network interfaces: %w", err)
+	}
+	if spec != "" {
+		return matchInterface(ne, list, spec)
+	}
+
+	if ne.DefaultRoute != nil {
+		if name, err := ne.DefaultRoute(); err == nil {
+			for _, iface := range list {
+				if iface.Name != name {
+					continue
+				}
+				if ni, err := ipv4Interface(ne, iface); err == nil {
+					return ni, nil
+				}
+			}
+		}
+	}
+	for _, iface := range list {
+		if !validWiredInterfaces[iface.Name] {
+			continue
+		}
+		if ni, err := ipv4Interface(ne, iface); err == nil {
+			return ni, nil
+		}
+	}
+	for _, iface := range list {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if ni, err := ipv4Interface(ne, iface); err == nil {
+			return ni, nil
+		}
+	}
+	return NetworkInterface{}, errors.New("no network interface with an IPV4 address found")
+}
+
+// selectInterfaces picks the interfaces to serve on from a comma separated
+// list of specs as understood by selectInterface.  An empty spec picks a
+// single interface automatically.
+func selectInterfaces(ne NetworkEnumerator, spec string) ([]NetworkInterface, error) {
+	var ifaces []NetworkInterface
+	for _, item := range strings.Split(spec, ",") {
+		iface, err := selectInterface(ne, strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		for _, selected := range ifaces {
+			if selected.Name == iface.Name {
+				return nil, fmt.Errorf("network interface %s selected twice", iface.Name)
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+func matchInterface(ne NetworkEnumerator, list []net.Interface, spec string) (NetworkInterface, error) {
+	if strings.Contains(spec, "/") {
+		_, cidr, err := net.ParseCIDR(spec)
+		if err != nil {
+			return NetworkInterface{}, err
+		}
+		for _, iface := range list {
+			ni, err := ipv4Interface(ne, iface)
+			if err == nil && cidr.Contains(ni.Address.IP) {
+				return ni, nil
+			}
+		}
+		return NetworkInterface{}, fmt.Errorf("no network interface with an address in %s", spec)
+	}
+
+	index, err := strconv.Atoi(spec)
+	for _, iface := range list {
+		if (err == nil && iface.Index == index) || iface.Name == spec {
+			return ipv4Interface(ne, iface)
+		}
+	}
+	return NetworkInterface{}, fmt.Errorf("network interface %s not found", spec)
+}
+
+// ipv4Interface returns iface with its first IPv4 address
+func ipv4Interface(ne NetworkEnumerator, iface net.Interface) (NetworkInterface, error) {
+	addrs, err := ne.Addrs(&iface)
+	if err != nil {
+		return NetworkInterface{}, fmt.Errorf("failed getting interface addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		ip, ipNet, err := net.ParseCIDR(addr.String())
+		if err != nil || ip.To4() == nil {
+			continue
+		}
+		return NetworkInterface{
+			Name:    iface.Name,
+			Index:   iface.Index,
+			Address: &net.IPNet{IP: ip.To4(), Mask: ipNet.Mask},
+		}, nil
+	}
+	return NetworkInterface{}, fmt.Errorf("no IPV4 address found on %s", iface.Name)
+}
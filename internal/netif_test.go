@@ -0,0 +1,128 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAddr string
+
+func (a testAddr) Network() string { return "ip+net" }
+func (a testAddr) String() string  { return string(a) }
+
+// newTestEnumerator enumerates enp3s0 (10.20.30.34/24), br0 (192.168.5.1/16)
+// and lo, with enp3s0 holding the default route when defaultRoute is set.
+func newTestEnumerator(defaultRoute string) NetworkEnumerator {
+	interfaces := []net.Interface{
+		{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Index: 2, Name: "enp3s0", Flags: net.FlagUp},
+		{Index: 5, Name: "br0", Flags: net.FlagUp},
+	}
+	addrs := map[string][]net.Addr{
+		"lo":     {testAddr("127.0.0.1/8")},
+		"enp3s0": {testAddr("fe80::1/64"), testAddr("10.20.30.34/24")},
+		"br0":    {testAddr("192.168.5.1/16")},
+	}
+	ne := NetworkEnumerator{
+		Interfaces: func() ([]net.Interface, error) { return interfaces, nil },
+		Addrs:      func(i *net.Interface) ([]net.Addr, error) { return addrs[i.Name], nil },
+	}
+	if defaultRoute != "" {
+		ne.DefaultRoute = func() (string, error) { return defaultRoute, nil }
+	}
+	return ne
+}
+
+func TestSelectInterfaceByName(t *testing.T) {
+	ni, err := selectInterface(newTestEnumerator(""), "br0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "br0", ni.Name)
+	assert.Equal(t, 5, ni.Index)
+	assert.Equal(t, "192.168.5.1/16", ni.Address.String())
+}
+
+func TestSelectInterfaceByIndex(t *testing.T) {
+	ni, err := selectInterface(newTestEnumerator(""), "2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "enp3s0", ni.Name)
+	assert.Equal(t, "10.20.30.34", ni.Address.IP.String())
+}
+
+func TestSelectInterfaceByCIDR(t *testing.T) {
+	ni, err := selectInterface(newTestEnumerator(""), "192.168.0.0/16")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "br0", ni.Name)
+
+	_, err = selectInterface(newTestEnumerator(""), "172.16.0.0/12")
+	assert.Error(t, err)
+
+	_, err = selectInterface(newTestEnumerator(""), "172.16.0.0/40")
+	assert.Error(t, err)
+}
+
+func TestSelectInterfaceNotFound(t *testing.T) {
+	_, err := selectInterface(newTestEnumerator(""), "eth9")
+
+	assert.Error(t, err)
+}
+
+func TestSelectInterfaceDefaultRoute(t *testing.T) {
+	ni, err := selectInterface(newTestEnumerator("br0"), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "br0", ni.Name)
+}
+
+func TestSelectInterfaceFirstUp(t *testing.T) {
+	ne := newTestEnumerator("")
+	ne.DefaultRoute = func() (string, error) { return "", errors.New("no route") }
+
+	ni, err := selectInterface(ne, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "enp3s0", ni.Name)
+}
+
+func TestSelectInterfaces(t *testing.T) {
+	ifaces, err := selectInterfaces(newTestEnumerator(""), "enp3s0, 5")
+
+	assert.NoError(t, err)
+	assert.Len(t, ifaces, 2)
+	assert.Equal(t, "enp3s0", ifaces[0].Name)
+	assert.Equal(t, "br0", ifaces[1].Name)
+
+	ifaces, err = selectInterfaces(newTestEnumerator("br0"), "")
+	assert.NoError(t, err)
+	assert.Len(t, ifaces, 1)
+	assert.Equal(t, "br0", ifaces[0].Name)
+
+	_, err = selectInterfaces(newTestEnumerator(""), "enp3s0,wlan0")
+	assert.Error(t, err)
+	_, err = selectInterfaces(newTestEnumerator(""), "br0,192.168.0.0/16")
+	assert.Error(t, err)
+}
+
+func TestSelectInterfaceErrors(t *testing.T) {
+	ne := NetworkEnumerator{
+		Interfaces: func() ([]net.Interface, error) { return nil, errors.New("failed") },
+	}
+	_, err := selectInterface(ne, "")
+	assert.Error(t, err)
+
+	ne = newTestEnumerator("")
+	ne.Addrs = func(*net.Interface) ([]net.Addr, error) { return nil, errors.New("failed") }
+	_, err = selectInterface(ne, "")
+	assert.Error(t, err)
+	_, err = selectInterface(ne, "enp3s0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxOptionLen is the most an option length byte can describe
+const maxOptionLen = 255
+
+// The Option* constructors below encode option values in network byte order
+// as RFC 2131 requires.
+
+func OptionUint8(code OptionCode, v uint8) Option {
+	return Option{Code: code, Value: []byte{v}}
+}
+
+func OptionUint16(code OptionCode, v uint16) Option {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, v)
+	return Option{Code: code, Value: value}
+}
+
+func OptionUint32(code OptionCode, v uint32) Option {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, v)
+	return Option{Code: code, Value: value}
+}
+
+func OptionBool(code OptionCode, v bool) Option {
+	if v {
+		return Option{Code: code, Value: []byte{1}}
+	}
+	return Option{Code: code, Value: []byte{0}}
+}
+
+// OptionIPList encodes one or more IPv4 addresses
+func OptionIPList(code OptionCode, ips ...net.IP) (Option, error) {
+	if len(ips) == 0 {
+		return Option{}, fmt.Errorf("option %d: at least one address is required", code)
+	}
+	value := make([]byte, 0, len(ips)*net.IPv4len)
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return Option{}, fmt.Errorf("option %d: %s is not an IPv4 address", code, ip)
+		}
+		value = append(value, ip4...)
+	}
+	if len(value) > maxOptionLen {
+		return Option{}, fmt.Errorf("option %d: too many addresses (%d)", code, len(ips))
+	}
+	return Option{Code: code, Value: value}, nil
+}
+
+// OptionString encodes a non-empty string of at most 255 bytes
+func OptionString(code OptionCode, s string) (Option, error) {
+	if len(s) == 0 || len(s) > maxOptionLen {
+		return Option{}, fmt.Errorf("option %d: length %d outside 1 to %d", code, len(s), maxOptionLen)
+	}
+	return Option{Code: code, Value: []byte(s)}, nil
+}
+
+// OptionDomain encodes a domain name in the form NormalizeDomain returns
+func OptionDomain(code OptionCode, name string) (Option, error) {
+	ascii, err := NormalizeDomain(name)
+	if err != nil {
+		return Option{}, fmt.Errorf("option %d: %w", code, err)
+	}
+	return Option{Code: code, Value: []byte(ascii)}, nil
+}
+
+// OptionDomainList encodes one or more domain names in DNS wire format with
+// name compression, as option 119 carries them, RFC 3397.  The value may
+// be longer than 255 bytes, it is split when the option is packed.
+func OptionDomainList(code OptionCode, names ...string) (Option, error) {
+	if len(names) == 0 {
+		return Option{}, fmt.Errorf("option %d: at least one domain name is required", code)
+	}
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		var err error
+		if normalized[i], err = NormalizeDomain(name); err != nil {
+			return Option{}, fmt.Errorf("option %d: %w", code, err)
+		}
+	}
+	return Option{Code: code, Value: encodeDomainList(normalized)}, nil
+}
+
+// OptionFQDN encodes a client FQDN option as a server sends it, with both
+// response codes set to 255 as RFC 4702 section 2.2 requires
+func OptionFQDN(code OptionCode, fqdn ClientFQDN) (Option, error) {
+	if fqdn.Name != "" {
+		if _, err := NormalizeDomain(fqdn.Name); err != nil {
+			return Option{}, fmt.Errorf("option %d: %w", code, err)
+		}
+	}
+	value := append([]byte{fqdn.Flags, 255, 255}, fqdn.wireName()...)
+	if len(value) > maxOptionLen {
+		return Option{}, fmt.Errorf("option %d: name too long", code)
+	}
+	return Option{Code: code, Value: value}, nil
+}
+
+// ParseIPList parses a comma separated list of IPv4 addresses as given on
+// the command line.  An empty list yields no addresses.
+func ParseIPList(list string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ip := net.ParseIP(item).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", item)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
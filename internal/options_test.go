@@ -0,0 +1,96 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionIntegers(t *testing.T) {
+	assert.Equal(t, Option{Code: OptionDefaultTTL, Value: []byte{64}}, OptionUint8(OptionDefaultTTL, 64))
+	assert.Equal(t, Option{Code: OptionCode(57), Value: []byte{0x05, 0xdc}}, OptionUint16(OptionCode(57), 1500))
+	// 86400 seconds goes on the wire as 00 01 51 80
+	assert.Equal(t, Option{Code: OptionIPLeaseTime, Value: []byte{0x00, 0x01, 0x51, 0x80}}, OptionUint32(OptionIPLeaseTime, 86400))
+}
+
+func TestOptionBool(t *testing.T) {
+	assert.Equal(t, []byte{1}, OptionBool(OptionCode(19), true).Value)
+	assert.Equal(t, []byte{0}, OptionBool(OptionCode(19), false).Value)
+}
+
+func TestOptionIPList(t *testing.T) {
+	opt, err := OptionIPList(OptionDomainNameServer, net.ParseIP("10.0.0.1"), net.IPv4(10, 0, 0, 2).To4())
+
+	assert.NoError(t, err)
+	assert.Equal(t, Option{Code: OptionDomainNameServer, Value: []byte{10, 0, 0, 1, 10, 0, 0, 2}}, opt)
+}
+
+func TestOptionIPListErrors(t *testing.T) {
+	_, err := OptionIPList(OptionDomainNameServer)
+	assert.Error(t, err)
+
+	_, err = OptionIPList(OptionDomainNameServer, net.ParseIP("fe80::1"))
+	assert.Error(t, err)
+
+	ips := make([]net.IP, 64)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, 0, byte(i))
+	}
+	_, err = OptionIPList(OptionDomainNameServer, ips...)
+	assert.Error(t, err)
+}
+
+func TestOptionString(t *testing.T) {
+	opt, err := OptionString(OptionDomainName, "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("example.com"), opt.Value)
+
+	_, err = OptionString(OptionDomainName, "")
+	assert.Error(t, err)
+
+	_, err = OptionString(OptionDomainName, strings.Repeat("a", 256))
+	assert.Error(t, err)
+}
+
+func TestSetDHCPOptionsNetworkOrder(t *testing.T) {
+	opts, err := setDHCPOptions(NewConfig("test.com"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x01, 0x51, 0x80}, optionValue(opts, OptionIPLeaseTime))
+	assert.Equal(t, []byte{0x00, 0x00, 0xa8, 0xc0}, optionValue(opts, OptionRenewalTime))
+	assert.Equal(t, []byte{0x00, 0x01, 0x27, 0x50}, optionValue(opts, OptionRebindingTime))
+}
+
+func TestSetDHCPOptionsInvalid(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.DNSServers = []net.IP{net.ParseIP("fe80::1")}
+	_, err := setDHCPOptions(cfg)
+	assert.Error(t, err)
+
+	cfg = NewConfig("test.com")
+	cfg.SubnetMask = net.IP{255, 255}
+	_, err = setDHCPOptions(cfg)
+	assert.Error(t, err)
+
+	_, err = setDHCPOptions(NewConfig(strings.Repeat("a", 256)))
+	assert.Error(t, err)
+}
+
+func TestParseIPList(t *testing.T) {
+	ips, err := ParseIPList("10.0.0.53, 10.0.1.53,")
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{{10, 0, 0, 53}, {10, 0, 1, 53}}, ips)
+
+	ips, err = ParseIPList("")
+	assert.NoError(t, err)
+	assert.Empty(t, ips)
+
+	_, err = ParseIPList("10.0.0.53,dns.example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,168 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// minMaxMessageSize is the smallest IP datagram every DHCP client must
+	// accept, and the smallest legal option 57 value, RFC 2132 section 9.10
+	minMaxMessageSize = 576
+	ipUDPHeaderLen    = 28
+
+	snameFieldLen = 64
+	fileFieldLen  = 128
+)
+
+// essentialOptions go ahead of the options a client requested: the mask
+// must precede the router (RFC 2132 section 3.5), OFFERs and ACKs must
+// carry the lease time, and the suffix is what RPE is there to deliver.
+var essentialOptions = []OptionCode{
+	OptionSubnetMask,
+	OptionIPLeaseTime,
+	OptionDomainName,
+}
+
+// maxReplyLen returns the size of the largest DHCP message the client that
+// sent reqOptions accepts, from its option 57 or the RFC 2131 minimum.
+func maxReplyLen(reqOptions Options) int {
+	size := minMaxMessageSize
+	if v := reqOptions[OptionMaximumMessageSize]; len(v) == 2 {
+		if requested := int(binary.BigEndian.Uint16(v)); requested > size {
+			size = requested
+		}
+	}
+	return size - ipUDPHeaderLen
+}
+
+// prioritiseOptions orders opts for a client that sent the parameter
+// request list prl: essential options first, then those requested in the
+// order asked for, then the rest in their configured order.
+func prioritiseOptions(opts []Option, prl []byte) []Option {
+	ordered := make([]Option, 0, len(opts))
+	taken := make([]bool, len(opts))
+	take := func(code OptionCode) {
+		for i, opt := range opts {
+			if !taken[i] && opt.Code == code {
+				ordered = append(ordered, opt)
+				taken[i] = true
+			}
+		}
+	}
+	for _, code := range essentialOptions {
+		take(code)
+	}
+	for _, code := range prl {
+		take(OptionCode(code))
+	}
+	for i, opt := range opts {
+		if !taken[i] {
+			ordered = append(ordered, opt)
+		}
+	}
+	return ordered
+}
+
+// optionLen returns the bytes an option with a value of n bytes takes.
+// Values longer than an option length byte can describe are split into
+// consecutive instances of the option, RFC 3396.
+func optionLen(n int) int {
+	instances := (n + maxOptionLen - 1) / maxOptionLen
+	if instances == 0 {
+		instances = 1
+	}
+	return 2*instances + n
+}
+
+// appendOption appends the option code with value to b, split as
+// optionLen describes
+func appendOption(b []byte, code OptionCode, value []byte) []byte {
+	for {
+		n := len(value)
+		if n > maxOptionLen {
+			n = maxOptionLen
+		}
+		b = append(b, byte(code), byte(n))
+		b = append(b, value[:n]...)
+		value = value[n:]
+		if len(value) == 0 {
+			return b
+		}
+	}
+}
+
+// optionField is one of the areas of a packet options are written to
+type optionField struct {
+	free    int // bytes left, not counting the end option
+	options []Option
+}
+
+func (f *optionField) place(opt Option) bool {
+	if optionLen(len(opt.Value)) > f.free {
+		return false
+	}
+	f.free -= optionLen(len(opt.Value))
+	f.options = append(f.options, opt)
+	return true
+}
+
+// packOptions appends opts to packet so that it does not grow past maxLen
+// bytes.  Options that do not fit in the options field overload the file
+// and then the sname field as described in RFC 2131 section 4.1.  Options
+// are placed in the order given and those that fit nowhere are left out
+// and returned, so opts should be sorted by priority.  A long option is
+// kept within one field, split as appendOption does.
+func packOptions(packet Packet, opts []Option, maxLen int) (Packet, []Option) {
+	main := optionField{free: maxLen - len(packet)}
+	total := 0
+	for _, opt := range opts {
+		total += optionLen(len(opt.Value))
+	}
+	if total <= main.free {
+		for _, opt := range opts {
+			packet.AddOption(opt.Code, opt.Value)
+		}
+		return packet, nil
+	}
+
+	main.free -= 3 // the overload option itself
+	file := optionField{free: fileFieldLen - 1}
+	sname := optionField{free: snameFieldLen - 1}
+	var dropped []Option
+	for _, opt := range opts {
+		if !main.place(opt) && !file.place(opt) && !sname.place(opt) {
+			dropped = append(dropped, opt)
+		}
+	}
+
+	var overload byte
+	for _, field := range []struct {
+		field *optionField
+		area  []byte
+		flag  byte
+	}{{&file, packet.File(), overloadFile}, {&sname, packet.SName(), overloadSName}} {
+		if len(field.field.options) == 0 {
+			continue
+		}
+		overload |= field.flag
+		var encoded []byte
+		for _, opt := range field.field.options {
+			encoded = appendOption(encoded, opt.Code, opt.Value)
+		}
+		i := copy(field.area, append(encoded, byte(End)))
+		for ; i < len(field.area); i++ {
+			field.area[i] = byte(Pad)
+		}
+	}
+	if overload != 0 {
+		packet.AddOption(OptionOverload, []byte{overload})
+	}
+	for _, opt := range main.options {
+		packet.AddOption(opt.Code, opt.Value)
+	}
+	return packet, dropped
+}
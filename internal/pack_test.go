@@ -0,0 +1,189 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestLargeOptions returns n site specific options of 200 bytes each,
+// more than the minimum message size holds when n is 2 or more
+func newTestLargeOptions(n int) []Option {
+	var opts []Option
+	for i := 0; i < n; i++ {
+		opts = append(opts, Option{Code: OptionCode(224 + i), Value: bytes.Repeat([]byte{byte('a' + i)}, 200)})
+	}
+	return opts
+}
+
+func TestMaxReplyLen(t *testing.T) {
+	assert.Equal(t, 548, maxReplyLen(Options{}))
+	assert.Equal(t, 1472, maxReplyLen(Options{OptionMaximumMessageSize: {0x05, 0xdc}}))
+	assert.Equal(t, 548, maxReplyLen(Options{OptionMaximumMessageSize: {0x01, 0x2c}}))
+	assert.Equal(t, 548, maxReplyLen(Options{OptionMaximumMessageSize: {0x05}}))
+}
+
+func TestOptionLen(t *testing.T) {
+	assert.Equal(t, 2, optionLen(0))
+	assert.Equal(t, 257, optionLen(255))
+	assert.Equal(t, 260, optionLen(256))
+	assert.Equal(t, 514, optionLen(510))
+	assert.Equal(t, 517, optionLen(511))
+}
+
+func TestAddOptionLong(t *testing.T) {
+	value := bytes.Repeat([]byte("x"), 600)
+	packet := NewPacket(bootReply)
+
+	packet.AddOption(OptionCode(224), value)
+
+	// split into 255, 255 and 90 bytes, RFC 3396
+	assert.Equal(t, []byte{224, 255}, []byte(packet[240:242]))
+	assert.Equal(t, []byte{224, 255}, []byte(packet[497:499]))
+	assert.Equal(t, []byte{224, 90}, []byte(packet[754:756]))
+	options, err := packet.Options()
+	assert.NoError(t, err)
+	assert.Equal(t, value, options[224])
+}
+
+func TestPrioritiseOptions(t *testing.T) {
+	opts := []Option{
+		{Code: OptionDomainNameServer}, {Code: OptionRouter}, {Code: OptionSubnetMask}, {Code: OptionDomainName},
+		{Code: OptionIPLeaseTime}, {Code: OptionDefaultTTL}, {Code: OptionNTPServers},
+	}
+
+	var codes []OptionCode
+	for _, opt := range prioritiseOptions(opts, []byte{42, 3, 6, 1, 99}) {
+		codes = append(codes, opt.Code)
+	}
+
+	assert.Equal(t, []OptionCode{
+		OptionSubnetMask, OptionIPLeaseTime, OptionDomainName,
+		OptionNTPServers, OptionRouter, OptionDomainNameServer,
+		OptionDefaultTTL,
+	}, codes)
+	assert.Len(t, prioritiseOptions(opts, nil), len(opts))
+}
+
+func TestPackOptionsFits(t *testing.T) {
+	opts := newTestLargeOptions(1)
+
+	packet, dropped := packOptions(NewPacket(bootReply), opts, 548)
+
+	assert.Empty(t, dropped)
+	options, err := packet.Options()
+	assert.NoError(t, err)
+	assert.Nil(t, options[OptionOverload])
+	assert.Equal(t, opts[0].Value, options[opts[0].Code])
+	assert.Equal(t, make([]byte, fileFieldLen), packet.File())
+}
+
+func TestPackOptionsOverload(t *testing.T) {
+	opts := append(newTestLargeOptions(1),
+		Option{Code: 250, Value: make([]byte, 100)},
+		Option{Code: OptionDomainName, Value: bytes.Repeat([]byte("x"), 120)},
+		Option{Code: 251, Value: make([]byte, 50)},
+		Option{Code: OptionNTPServers, Value: []byte{10, 0, 0, 123}},
+	)
+
+	packet, dropped := packOptions(NewPacket(bootReply), opts, 548)
+
+	assert.Empty(t, dropped)
+	assert.LessOrEqual(t, len(packet), 548)
+	parsed, err := ParsePacket(packet)
+	assert.NoError(t, err)
+	options, _ := parsed.Options()
+	assert.Equal(t, []byte{overloadBoth}, options[OptionOverload])
+	for _, opt := range opts {
+		assert.Equal(t, opt.Value, options[opt.Code], opt.Code)
+	}
+	assert.Equal(t, byte(OptionDomainName), packet.File()[0])
+	assert.Equal(t, []byte{251, 50}, packet.SName()[:2])
+	assert.Equal(t, []byte{byte(OptionNTPServers), 4}, packet.SName()[52:54])
+}
+
+func TestPackOptionsDrops(t *testing.T) {
+	opts := newTestLargeOptions(3)
+
+	packet, dropped := packOptions(NewPacket(bootReply), opts, 548)
+
+	assert.Equal(t, opts[1:], dropped)
+	options, err := packet.Options()
+	assert.NoError(t, err)
+	assert.Equal(t, opts[0].Value, options[opts[0].Code])
+	assert.Nil(t, options[opts[1].Code])
+	assert.Nil(t, options[OptionOverload])
+}
+
+func TestPackOptionsLong(t *testing.T) {
+	long := Option{Code: OptionCode(224), Value: bytes.Repeat([]byte("x"), 300)}
+	opts := []Option{long, {Code: OptionDomainName, Value: []byte("test.com")}}
+
+	packet, dropped := packOptions(NewPacket(bootReply), opts, 1472)
+	assert.Empty(t, dropped)
+	options, _ := packet.Options()
+	assert.Equal(t, long.Value, options[long.Code])
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+
+	// too long for any field of a minimum size message
+	long.Value = bytes.Repeat([]byte("x"), 500)
+	_, dropped = packOptions(NewPacket(bootReply), []Option{long}, 548)
+	assert.Equal(t, []Option{long}, dropped)
+}
+
+func TestCreateReplyPacketRequestedFirst(t *testing.T) {
+	serverIP := net.IPv4(10, 20, 30, 34).To4()
+	opts := append(newTestLargeOptions(2), Option{Code: OptionDomainName, Value: []byte("test.com")})
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionParameterRequestList, []byte{225})
+
+	reply, err := createReplyPacket(req, dhcpOffer, serverIP, net.IPv4(10, 20, 30, 131), opts)
+
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(reply), 548)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+	assert.Equal(t, opts[1].Value, options[opts[1].Code])
+	assert.Nil(t, options[opts[0].Code])
+
+	// a client accepting larger messages gets everything in the options field
+	req.AddOption(OptionMaximumMessageSize, []byte{0x05, 0xdc})
+	reply, _ = createReplyPacket(req, dhcpOffer, serverIP, net.IPv4(10, 20, 30, 131), opts)
+	options, _ = reply.Options()
+	assert.Nil(t, options[OptionOverload])
+	assert.Equal(t, opts[0].Value, options[opts[0].Code])
+	assert.Equal(t, opts[1].Value, options[opts[1].Code])
+}
+
+func TestCreateReplyPacketRelayInfoLast(t *testing.T) {
+	info := []byte{relayCircuitID, 4, 'p', 'o', 'r', 't'}
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionRelayAgentInformation, info)
+
+	reply, err := createReplyPacket(req, dhcpOffer, net.IPv4(10, 20, 30, 34).To4(), net.IPv4(10, 50, 0, 101), newTestLargeOptions(3))
+
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(reply), 548)
+	end := bytes.LastIndexByte(reply, byte(End))
+	assert.Equal(t, append([]byte{byte(OptionRelayAgentInformation), 6}, info...), []byte(reply[end-8:end]))
+}
+
+func TestCreateReplyPacketLongRelayInfo(t *testing.T) {
+	info := append([]byte{relayCircuitID, 255}, bytes.Repeat([]byte("p"), 255)...)
+	info = append(info, relayRemoteID, 20)
+	info = append(info, bytes.Repeat([]byte("r"), 20)...)
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionRelayAgentInformation, info)
+
+	reply, err := createReplyPacket(req, dhcpOffer, net.IPv4(10, 20, 30, 34).To4(), net.IPv4(10, 50, 0, 101), nil)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, info, options[OptionRelayAgentInformation])
+}
@@ -0,0 +1,140 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Options holds the decoded options of a packet keyed by code.  Values of
+// options that appear more than once are concatenated as per RFC 3396.
+type Options map[OptionCode][]byte
+
+// Errors returned while decoding a packet
+var (
+	ErrPacketTooShort  = errors.New("packet shorter than the fixed DHCP header")
+	ErrInvalidOpCode   = errors.New("invalid op code")
+	ErrInvalidHLen     = errors.New("hardware address length exceeds chaddr field")
+	ErrInvalidCookie   = errors.New("missing DHCP magic cookie")
+	ErrOptionTruncated = errors.New("option runs past the end of its field")
+	ErrMissingEnd      = errors.New("option field not terminated by end option")
+	ErrInvalidOverload = errors.New("invalid option overload value")
+)
+
+// OptionError reports a malformed option, identified by its code and the
+// offset of its first byte within the packet.
+type OptionError struct {
+	Code   OptionCode
+	Offset int
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("option %d at offset %d: %s", e.Code, e.Offset, e.Err)
+}
+
+func (e *OptionError) Unwrap() error { return e.Err }
+
+const (
+	minPacketLen = 240 // fixed header plus magic cookie
+
+	overloadFile  byte = 1
+	overloadSName byte = 2
+	overloadBoth  byte = 3
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// ParsePacket validates data as a DHCP message and returns a copy of it as a
+// Packet.  The fixed header and every option, including options carried in
+// overloaded sname and file fields, are checked.
+func ParsePacket(data []byte) (Packet, error) {
+	if len(data) < minPacketLen {
+		return nil, ErrPacketTooShort
+	}
+	p := make(Packet, len(data))
+	copy(p, data)
+
+	if op := p.OpCode(); op != bootRequest && op != bootReply {
+		return nil, ErrInvalidOpCode
+	}
+	if p.HLen() > 16 {
+		return nil, ErrInvalidHLen
+	}
+	if !bytes.Equal(p.Cookie(), magicCookie) {
+		return nil, ErrInvalidCookie
+	}
+	if _, err := p.Options(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Options decodes the options of p.  When option 52 is present the file
+// and/or sname fields are decoded too, in the order given by RFC 2131.
+func (p Packet) Options() (Options, error) {
+	if len(p) < minPacketLen {
+		return nil, ErrPacketTooShort
+	}
+	options := make(Options)
+	if err := decodeOptions(options, p, minPacketLen, len(p)); err != nil {
+		return nil, err
+	}
+
+	overload, ok := options[OptionOverload]
+	if !ok {
+		return options, nil
+	}
+	if len(overload) != 1 || overload[0] < overloadFile || overload[0] > overloadBoth {
+		return nil, &OptionError{Code: OptionOverload, Offset: minPacketLen, Err: ErrInvalidOverload}
+	}
+	if overload[0]&overloadFile != 0 {
+		if err := decodeOptions(options, p, 108, 236); err != nil {
+			return nil, err
+		}
+	}
+	if overload[0]&overloadSName != 0 {
+		if err := decodeOptions(options, p, 44, 108); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// decodeOptions adds the options found in p[start:end] to options.
+func decodeOptions(options Options, p Packet, start int, end int) error {
+	i := start
+	for i < end {
+		code := OptionCode(p[i])
+		switch code {
+		case Pad:
+			i++
+			continue
+		case End:
+			return nil
+		}
+		if i+1 >= end || i+2+int(p[i+1]) > end {
+			return &OptionError{Code: code, Offset: i, Err: ErrOptionTruncated}
+		}
+		length := int(p[i+1])
+		options[code] = append(options[code], p[i+2:i+2+length]...)
+		i += 2 + length
+	}
+	return &OptionError{Code: End, Offset: end, Err: ErrMissingEnd}
+}
+
+// MessageType returns the value of option 53, or 0 when it is absent or
+// malformed.
+func (o Options) MessageType() MessageType {
+	if v := o[OptionDHCPMessageType]; len(v) == 1 {
+		return MessageType(v[0])
+	}
+	return 0
+}
+
+func (p Packet) SName() []byte { return p[44:108] }
+func (p Packet) File() []byte  { return p[108:236] }
@@ -0,0 +1,132 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePacket(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionHostName, []byte("amt"))
+	req.PadToMinSize()
+
+	p, err := ParsePacket(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, req, p)
+	options, err := p.Options()
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpDiscover, options.MessageType())
+	assert.Equal(t, []byte("amt"), options[OptionHostName])
+}
+
+func TestParsePacketCopies(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+
+	p, _ := ParsePacket(req)
+	req[0] = 0
+
+	assert.Equal(t, bootRequest, p.OpCode())
+}
+
+func TestParsePacketHeaderErrors(t *testing.T) {
+	_, err := ParsePacket(make([]byte, 100))
+	assert.Equal(t, ErrPacketTooShort, err)
+
+	p := newTestRequest(dhcpDiscover)
+	p.SetOpCode(3)
+	_, err = ParsePacket(p)
+	assert.Equal(t, ErrInvalidOpCode, err)
+
+	p = newTestRequest(dhcpDiscover)
+	p[2] = 17
+	_, err = ParsePacket(p)
+	assert.Equal(t, ErrInvalidHLen, err)
+
+	p = newTestRequest(dhcpDiscover)
+	p.SetCookie([]byte{1, 2, 3, 4})
+	_, err = ParsePacket(p)
+	assert.Equal(t, ErrInvalidCookie, err)
+}
+
+func TestParsePacketTruncatedOption(t *testing.T) {
+	p := newTestRequest(dhcpDiscover)
+	p = append(p[:len(p)-1], byte(OptionHostName), 10, 'a')
+
+	_, err := ParsePacket(p)
+
+	var optErr *OptionError
+	assert.True(t, errors.As(err, &optErr))
+	assert.Equal(t, OptionHostName, optErr.Code)
+	assert.Equal(t, 243, optErr.Offset)
+	assert.True(t, errors.Is(err, ErrOptionTruncated))
+}
+
+func TestParsePacketMissingEnd(t *testing.T) {
+	p := newTestRequest(dhcpDiscover)
+	p = p[:len(p)-1]
+
+	_, err := ParsePacket(p)
+
+	assert.True(t, errors.Is(err, ErrMissingEnd))
+}
+
+func TestOptionsPadAndEnd(t *testing.T) {
+	p := NewPacket(bootRequest)
+	p = append(p[:240], byte(Pad), byte(Pad), byte(OptionDHCPMessageType), 1, byte(dhcpRequest), byte(End), byte(OptionHostName), 1, 'x')
+
+	options, err := p.Options()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(options))
+	assert.Equal(t, dhcpRequest, options.MessageType())
+}
+
+func TestOptionsConcatenated(t *testing.T) {
+	p := newTestRequest(dhcpDiscover)
+	p.AddOption(OptionDomainName, []byte("example"))
+	p.AddOption(OptionDomainName, []byte(".com"))
+
+	options, err := p.Options()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("example.com"), options[OptionDomainName])
+}
+
+func TestOptionsOverload(t *testing.T) {
+	p := newTestRequest(dhcpDiscover)
+	p.AddOption(OptionOverload, []byte{overloadBoth})
+	copy(p.File(), []byte{byte(OptionHostName), 3, 'a', 'm', 't', byte(End)})
+	copy(p.SName(), []byte{byte(OptionDomainNameServer), 4, 8, 8, 8, 8, byte(End)})
+
+	options, err := p.Options()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("amt"), options[OptionHostName])
+	assert.Equal(t, net.IP{8, 8, 8, 8}, net.IP(options[OptionDomainNameServer]))
+}
+
+func TestOptionsOverloadErrors(t *testing.T) {
+	p := newTestRequest(dhcpDiscover)
+	p.AddOption(OptionOverload, []byte{4})
+	_, err := p.Options()
+	assert.True(t, errors.Is(err, ErrInvalidOverload))
+
+	p = newTestRequest(dhcpDiscover)
+	p.AddOption(OptionOverload, []byte{overloadSName})
+	_, err = p.Options()
+	assert.True(t, errors.Is(err, ErrMissingEnd))
+}
+
+func TestOptionsMessageType(t *testing.T) {
+	assert.Equal(t, MessageType(0), Options{}.MessageType())
+	assert.Equal(t, MessageType(0), Options{OptionDHCPMessageType: []byte{1, 2}}.MessageType())
+	assert.Equal(t, dhcpInform, Options{OptionDHCPMessageType: []byte{8}}.MessageType())
+}
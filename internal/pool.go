@@ -0,0 +1,254 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted is returned when no address in the pool is free
+var ErrPoolExhausted = errors.New("address pool exhausted")
+
+// ErrProbeLimit is returned when every address probed for an allocation
+// was in use
+var ErrProbeLimit = errors.New("addresses probed were in use")
+
+const (
+	defaultDeclineCooldown = 10 * time.Minute
+	offerHoldTime          = time.Minute // how long an offered address is kept for the client
+	// defaultMaxProbes bounds the probes of one allocation, requests are
+	// handled one at a time and each probe of a free address waits for its
+	// timeout
+	defaultMaxProbes = 2
+)
+
+// Prober checks whether an address is already in use on the network
+type Prober interface {
+	Probe(ip net.IP) (bool, error)
+}
+
+// Pool hands out addresses from a range, skipping excluded addresses,
+// addresses in use and addresses clients declined in the last Cooldown.
+type Pool struct {
+	start     uint32
+	end       uint32
+	exclude   map[uint32]bool
+	Cooldown  time.Duration // how long a declined address is left alone
+	Prober    Prober        // optional, probes addresses before they are offered
+	MaxProbes int           // probes per allocation before giving up on it
+
+	mu       sync.Mutex
+	declined map[uint32]time.Time // address to end of cool-down
+	offers   map[string]poolOffer // client MAC to pending offer
+}
+
+type poolOffer struct {
+	ip     uint32
+	expiry time.Time
+}
+
+// NewPool returns a pool of the addresses from start to end inclusive
+func NewPool(start net.IP, end net.IP, exclude []net.IP) (*Pool, error) {
+	if start.To4() == nil || end.To4() == nil {
+		return nil, errors.New("pool range must be IPv4")
+	}
+	p := &Pool{
+		start:     ipToUint32(start),
+		end:       ipToUint32(end),
+		exclude:   make(map[uint32]bool),
+		Cooldown:  defaultDeclineCooldown,
+		MaxProbes: defaultMaxProbes,
+		declined:  make(map[uint32]time.Time),
+		offers:    make(map[string]poolOffer),
+	}
+	if p.start > p.end {
+		return nil, fmt.Errorf("pool start %s is after end %s", start, end)
+	}
+	for _, ip := range exclude {
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("invalid excluded address %s", ip)
+		}
+		p.exclude[ipToUint32(ip)] = true
+	}
+	return p, nil
+}
+
+// ParsePool builds a pool from a "start-end" range and a comma separated
+// list of excluded addresses or "start-end" ranges.
+func ParsePool(poolRange string, exclude string) (*Pool, error) {
+	start, end, err := parseRange(poolRange)
+	if err != nil {
+		return nil, err
+	}
+	var excluded []net.IP
+	for _, item := range strings.Split(exclude, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, err := parseRange(item)
+		if err != nil {
+			return nil, err
+		}
+		for ip := ipToUint32(first); ip <= ipToUint32(last) && ip != 0; ip++ {
+			excluded = append(excluded, uint32ToIP(ip))
+		}
+	}
+	return NewPool(start, end, excluded)
+}
+
+// parseRange parses "start-end", or a single address as a range of one
+func parseRange(s string) (net.IP, net.IP, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start := net.ParseIP(strings.TrimSpace(parts[0])).To4()
+	end := start
+	if len(parts) == 2 {
+		end = net.ParseIP(strings.TrimSpace(parts[1])).To4()
+	}
+	if start == nil || end == nil {
+		return nil, nil, fmt.Errorf("invalid address range %q", s)
+	}
+	if ipToUint32(start) > ipToUint32(end) {
+		return nil, nil, fmt.Errorf("address range %q ends before it starts", s)
+	}
+	return start, end, nil
+}
+
+// Contains reports whether ip is within the pool range
+func (p *Pool) Contains(ip net.IP) bool {
+	if ip.To4() == nil {
+		return false
+	}
+	n := ipToUint32(ip)
+	return n >= p.start && n <= p.end
+}
+
+// Allocate returns the address to offer to mac.  A pending offer is
+// repeated, otherwise the lowest free address not reported by inUse is
+// chosen and held for the client for a short while.  The candidate is held
+// while it is probed, so probes run without blocking other clients.  After
+// MaxProbes addresses answered ErrProbeLimit is returned; they are declined,
+// so the next allocation for the client starts past them.
+func (p *Pool) Allocate(mac string, inUse func(net.IP) bool) (net.IP, error) {
+	for probes := 0; ; probes++ {
+		if probes >= p.MaxProbes && p.Prober != nil {
+			return nil, ErrProbeLimit
+		}
+		ip, pending, err := p.hold(mac, inUse)
+		if err != nil || pending || p.Prober == nil {
+			return ip, err
+		}
+		used, err := p.Prober.Probe(ip)
+		if err != nil {
+			log.Println("Error probing ", ip, ": ", err)
+			return ip, nil
+		}
+		if !used {
+			return ip, nil
+		}
+		log.Println("Address ", ip, " answered a probe, skipping it")
+		p.Decline(ip)
+	}
+}
+
+// hold picks the address to offer to mac and holds it for the client,
+// reporting whether it was already offered.
+func (p *Pool) hold(mac string, inUse func(net.IP) bool) (net.IP, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if offer, ok := p.offers[mac]; ok && now.Before(offer.expiry) {
+		return uint32ToIP(offer.ip), true, nil
+	}
+	for n := p.start; n <= p.end && n >= p.start; n++ {
+		ip := uint32ToIP(n)
+		if !p.free(n, mac, now) || inUse(ip) {
+			continue
+		}
+		p.offers[mac] = poolOffer{ip: n, expiry: now.Add(offerHoldTime)}
+		return ip, false, nil
+	}
+	return nil, false, ErrPoolExhausted
+}
+
+// Offered returns the address offered to mac, or nil if there is no pending
+// offer.
+func (p *Pool) Offered(mac string) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if offer, ok := p.offers[mac]; ok && time.Now().Before(offer.expiry) {
+		return uint32ToIP(offer.ip)
+	}
+	return nil
+}
+
+// Available reports whether mac may be given ip without an offer, as when a
+// client asks to keep an address after the server restarted.
+func (p *Pool) Available(ip net.IP, mac string, inUse func(net.IP) bool) bool {
+	if !p.Contains(ip) || inUse(ip) {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.free(ipToUint32(ip), mac, time.Now())
+}
+
+// Commit drops the pending offer to mac once the address is leased
+func (p *Pool) Commit(mac string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.offers, mac)
+}
+
+// Decline marks ip as bad for the cool-down period
+func (p *Pool) Decline(ip net.IP) {
+	if !p.Contains(ip) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := ipToUint32(ip)
+	p.declined[n] = time.Now().Add(p.Cooldown)
+	for mac, offer := range p.offers {
+		if offer.ip == n {
+			delete(p.offers, mac)
+		}
+	}
+}
+
+// free reports whether address n can go to mac, ignoring leases.  p.mu must
+// be held.
+func (p *Pool) free(n uint32, mac string, now time.Time) bool {
+	if p.exclude[n] {
+		return false
+	}
+	if until, ok := p.declined[n]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(p.declined, n)
+	}
+	for other, offer := range p.offers {
+		if offer.ip == n && other != mac && now.Before(offer.expiry) {
+			return false
+		}
+	}
+	return true
+}
+
+func ipToUint32(ip net.IP) uint32 { return binary.BigEndian.Uint32(ip.To4()) }
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
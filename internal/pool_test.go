@@ -0,0 +1,179 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testProber map[string]bool
+
+func (p testProber) Probe(ip net.IP) (bool, error) {
+	if ip.Equal(net.IPv4(10, 0, 0, 99)) {
+		return false, errors.New("probe failed")
+	}
+	return p[ip.String()], nil
+}
+
+func notInUse(net.IP) bool { return false }
+
+func TestParsePool(t *testing.T) {
+	p, err := ParsePool("10.0.0.10-10.0.0.20", "10.0.0.11, 10.0.0.13-10.0.0.14")
+
+	assert.NoError(t, err)
+	assert.True(t, p.Contains(net.ParseIP("10.0.0.10")))
+	assert.True(t, p.Contains(net.ParseIP("10.0.0.20")))
+	assert.False(t, p.Contains(net.ParseIP("10.0.0.21")))
+	assert.False(t, p.Contains(net.ParseIP("fe80::1")))
+	assert.Equal(t, map[uint32]bool{
+		ipToUint32(net.ParseIP("10.0.0.11")): true,
+		ipToUint32(net.ParseIP("10.0.0.13")): true,
+		ipToUint32(net.ParseIP("10.0.0.14")): true,
+	}, p.exclude)
+}
+
+func TestParsePoolErrors(t *testing.T) {
+	for _, tc := range [][2]string{
+		{"", ""},
+		{"10.0.0.20-10.0.0.10", ""},
+		{"10.0.0.10-fe80::1", ""},
+		{"10.0.0.10-10.0.0.20", "10.0.0.x"},
+		{"10.0.0.10-10.0.0.20", "10.0.0.5-10.0.0.1"},
+	} {
+		_, err := ParsePool(tc[0], tc[1])
+		assert.Error(t, err, tc)
+	}
+	_, err := NewPool(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"), nil)
+	assert.Error(t, err)
+	_, err = NewPool(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), []net.IP{nil})
+	assert.Error(t, err)
+}
+
+func TestParsePoolSingleAddress(t *testing.T) {
+	p, err := ParsePool("10.0.0.10", "")
+
+	assert.NoError(t, err)
+	ip, _ := p.Allocate("aa", notInUse)
+	assert.Equal(t, "10.0.0.10", ip.String())
+}
+
+func TestPoolAllocate(t *testing.T) {
+	p, _ := ParsePool("10.0.0.10-10.0.0.13", "10.0.0.10")
+	inUse := func(ip net.IP) bool { return ip.Equal(net.IPv4(10, 0, 0, 11)) }
+
+	first, err := p.Allocate("aa", inUse)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.12", first.String())
+
+	// the pending offer is repeated and held from other clients
+	again, _ := p.Allocate("aa", inUse)
+	assert.Equal(t, first, again)
+	second, _ := p.Allocate("bb", inUse)
+	assert.Equal(t, "10.0.0.13", second.String())
+	_, err = p.Allocate("cc", inUse)
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	assert.Equal(t, first, p.Offered("aa"))
+	assert.Nil(t, p.Offered("cc"))
+	p.Commit("aa")
+	assert.Nil(t, p.Offered("aa"))
+}
+
+func TestPoolOfferExpires(t *testing.T) {
+	p, _ := ParsePool("10.0.0.10-10.0.0.10", "")
+	p.Allocate("aa", notInUse)
+	p.offers["aa"] = poolOffer{ip: p.offers["aa"].ip, expiry: time.Now().Add(-time.Second)}
+
+	ip, err := p.Allocate("bb", notInUse)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.10", ip.String())
+}
+
+func TestPoolDecline(t *testing.T) {
+	p, _ := ParsePool("10.0.0.10-10.0.0.11", "")
+	ip, _ := p.Allocate("aa", notInUse)
+
+	p.Decline(ip)
+	p.Decline(net.ParseIP("192.168.0.1"))
+
+	assert.Nil(t, p.Offered("aa"))
+	next, _ := p.Allocate("aa", notInUse)
+	assert.Equal(t, "10.0.0.11", next.String())
+	assert.False(t, p.Available(ip, "bb", notInUse))
+
+	// usable again once the cool-down is over
+	p.declined[ipToUint32(ip)] = time.Now().Add(-time.Second)
+	assert.True(t, p.Available(ip, "bb", notInUse))
+}
+
+func TestPoolAvailable(t *testing.T) {
+	p, _ := ParsePool("10.0.0.10-10.0.0.20", "10.0.0.15")
+	p.Allocate("aa", notInUse)
+
+	assert.True(t, p.Available(net.ParseIP("10.0.0.12"), "bb", notInUse))
+	assert.True(t, p.Available(net.ParseIP("10.0.0.10"), "aa", notInUse))
+	assert.False(t, p.Available(net.ParseIP("10.0.0.10"), "bb", notInUse))
+	assert.False(t, p.Available(net.ParseIP("10.0.0.15"), "bb", notInUse))
+	assert.False(t, p.Available(net.ParseIP("10.0.0.21"), "bb", notInUse))
+	assert.False(t, p.Available(net.ParseIP("10.0.0.12"), "bb", func(net.IP) bool { return true }))
+}
+
+func TestPoolProbe(t *testing.T) {
+	p, _ := ParsePool("10.0.0.98-10.0.0.101", "")
+	p.Prober = testProber{"10.0.0.98": true, "10.0.0.100": false}
+
+	ip, err := p.Allocate("aa", notInUse)
+
+	// .98 answers, .99 cannot be probed so is offered anyway
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.99", ip.String())
+	assert.False(t, p.Available(net.ParseIP("10.0.0.98"), "bb", notInUse))
+}
+
+func TestPoolProbeLimit(t *testing.T) {
+	p, _ := ParsePool("10.0.0.100-10.0.0.103", "")
+	p.Prober = testProber{"10.0.0.100": true, "10.0.0.101": true, "10.0.0.102": true}
+
+	_, err := p.Allocate("aa", notInUse)
+	assert.Equal(t, ErrProbeLimit, err)
+
+	// the retry carries on past the addresses found in use
+	ip, err := p.Allocate("aa", notInUse)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.103", ip.String())
+}
+
+// lockingProber reads the pool while probing, which deadlocks if the pool
+// lock is held
+type lockingProber struct{ pool *Pool }
+
+func (p lockingProber) Probe(ip net.IP) (bool, error) {
+	return p.pool.Offered("aa") == nil, nil
+}
+
+func TestPoolProbeUnlocked(t *testing.T) {
+	p, _ := ParsePool("10.0.0.10-10.0.0.11", "")
+	p.Prober = lockingProber{p}
+	done := make(chan net.IP)
+
+	go func() {
+		ip, _ := p.Allocate("aa", notInUse)
+		done <- ip
+	}()
+
+	select {
+	case ip := <-done:
+		// the candidate is held for aa while it is probed
+		assert.Equal(t, "10.0.0.10", ip.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Allocate holds the pool lock while probing")
+	}
+}
@@ -0,0 +1,86 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// ICMPProber probes addresses with an ICMP echo request.  It needs a raw
+// socket, so RPE must run as root or with CAP_NET_RAW.
+type ICMPProber struct {
+	Timeout time.Duration
+}
+
+// Probe reports whether ip answered an echo request within the timeout
+func (p ICMPProber) Probe(ip net.IP) (bool, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	if _, err := conn.WriteTo(icmpEcho(id, 1), &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(p.Timeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return false, err
+	}
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok || !addr.IP.Equal(ip) || n < 8 {
+			continue
+		}
+		if buffer[0] == icmpEchoReply && binary.BigEndian.Uint16(buffer[4:6]) == id {
+			return true, nil
+		}
+	}
+}
+
+// icmpEcho builds an ICMP echo request
+func icmpEcho(id uint16, seq uint16) []byte {
+	msg := make([]byte, 8)
+	msg[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(msg[4:6], id)
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	binary.BigEndian.PutUint16(msg[2:4], checksum(msg))
+	return msg
+}
+
+// checksum computes the internet checksum of RFC 1071
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,38 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum(t *testing.T) {
+	// example from RFC 1071 section 3
+	data := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	assert.Equal(t, ^uint16(0xddf2), checksum(data))
+
+	assert.Equal(t, ^uint16(0x0100), checksum([]byte{0x01}))
+}
+
+func TestICMPEcho(t *testing.T) {
+	msg := icmpEcho(0x1234, 1)
+
+	assert.Equal(t, []byte{icmpEchoRequest, 0}, msg[:2])
+	assert.Equal(t, []byte{0x12, 0x34, 0, 1}, msg[4:])
+	assert.Equal(t, uint16(0), checksum(msg))
+}
+
+func TestICMPProberLoopback(t *testing.T) {
+	used, err := ICMPProber{Timeout: time.Second}.Probe(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		t.Skip("raw sockets not permitted: ", err)
+	}
+
+	assert.True(t, used)
+}
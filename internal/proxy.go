@@ -0,0 +1,114 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// proxiedOptions are copied from the ACK of the site DHCP server into the
+// one injected in proxy mode, so the client keeps the lease it was granted.
+var proxiedOptions = []OptionCode{
+	OptionSubnetMask,
+	OptionRouter,
+	OptionDomainNameServer,
+	OptionNTPServers,
+	OptionIPLeaseTime,
+	OptionRenewalTime,
+	OptionRebindingTime,
+}
+
+// snoopRequest records the requests of clients in proxy mode, it never
+// replies to them.
+func (s *Server) snoopRequest(req Packet, serverIP net.IP) (Packet, error) {
+	_, error := s.snooper.Observe(req)
+	return nil, error
+}
+
+// handleProxy follows an ACK sent by another DHCP server and returns an ACK
+// for the same lease that adds the DNS suffix.  The reply reuses the xid,
+// yiaddr and server identifier of the observed ACK so the client accepts it
+// as coming from the server that granted the lease.  Only clients of a
+// class, AMT by default, and clients reserved or registered in MPS are
+// injected into, once per lease.  A nil
+// packet is returned when there is nothing to inject.
+func (s *Server) handleProxy(observed Packet, serverIP net.IP) (Packet, error) {
+	if observed.OpCode() != bootReply {
+		return nil, nil
+	}
+	client, error := s.snooper.Observe(observed)
+	if error != nil {
+		return nil, error
+	}
+	options, error := observed.Options()
+	if error != nil {
+		return nil, error
+	}
+	if options.MessageType() != dhcpAck {
+		return nil, nil
+	}
+	serverID := net.IP(options[OptionServerIdentifier])
+	if len(serverID) != net.IPv4len || serverID.Equal(serverIP) {
+		return nil, nil
+	}
+	// ACKs to INFORM carry no lease
+	assignedIP := observed.YIAddr()
+	if assignedIP.Equal(net.IPv4zero) {
+		return nil, nil
+	}
+
+	// Only members of an allowed class, and clients reserved or registered
+	// in MPS, get the suffix
+	domainName := s.cfg.DNSSuffix
+	class, member := findClass(s.snooper.classes, client.Class)
+	if member && class.DomainName != "" {
+		domainName = class.DomainName
+	}
+	rpsDomain, assigned := s.cfg.RPS.Lookup(client.UUID)
+	if assigned {
+		domainName = rpsDomain.DomainSuffix
+	}
+	res, reserved := s.cfg.Reservations.Lookup(observed.CHAddr(), client.UUID)
+	if reserved && res.DomainName != "" {
+		domainName = res.DomainName
+	}
+	if !reserved && !assigned && (!member || class.Deny) {
+		return nil, nil
+	}
+	// The ACKs we inject are broadcast back to us as well
+	if !s.snooper.Inject(client.MAC) {
+		return nil, nil
+	}
+	domain, error := OptionDomain(OptionDomainName, domainName)
+	if error != nil {
+		return nil, error
+	}
+	var replyOptions []Option
+	for _, code := range proxiedOptions {
+		if value, ok := options[code]; ok {
+			replyOptions = append(replyOptions, Option{Code: code, Value: value})
+		}
+	}
+	replyOptions = append(replyOptions, domain)
+
+	expiry := client.Expiry
+	if expiry.IsZero() {
+		expiry = time.Now().Add(s.cfg.LeaseTime)
+	}
+	lease := Lease{
+		MAC:        client.MAC,
+		IP:         assignedIP,
+		Hostname:   string(options[OptionHostName]),
+		DomainName: domainName,
+		Expiry:     expiry,
+	}
+	if error := s.cfg.Leases.Put(lease); error != nil {
+		return nil, error
+	}
+	log.Println("Injecting ", domainName, " into lease of ", assignedIP, " to ", lease.MAC, " from ", serverID)
+	return createReplyPacket(observed, dhcpAck, serverID, assignedIP, replyOptions)
+}
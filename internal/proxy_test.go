@@ -0,0 +1,205 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var siteServerIP = net.IPv4(10, 20, 30, 1).To4()
+
+// newTestSiteAck returns the ACK a site DHCP server sends for 10.20.30.77
+func newTestSiteAck() Packet {
+	ack, _ := createReplyPacket(newTestRequest(dhcpRequest), dhcpAck, siteServerIP, net.IPv4(10, 20, 30, 77), []Option{
+		OptionUint32(OptionTimeOffset, 0),
+		{Code: OptionSubnetMask, Value: []byte{255, 255, 255, 0}},
+		{Code: OptionRouter, Value: []byte{10, 20, 30, 1}},
+		{Code: OptionDomainNameServer, Value: []byte{10, 20, 30, 2}},
+		OptionUint32(OptionIPLeaseTime, 3600),
+	})
+	return ack
+}
+
+func newTestProxy(t *testing.T) *Server {
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	cfg.BindToDevice = false
+	s, err := NewServer(cfg)
+	assert.NoError(t, err)
+	// the client asked for its lease as an AMT device
+	_, err = s.snooper.Observe(newTestAMTRequest())
+	assert.NoError(t, err)
+	return s
+}
+
+func TestHandleProxy(t *testing.T) {
+	s := newTestProxy(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := s.handleProxy(newTestSiteAck(), serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, reply.XId())
+	assert.Equal(t, "00:11:22:33:44:55", reply.CHAddr().String())
+	assert.Equal(t, net.IPv4(10, 20, 30, 77).To4(), reply.YIAddr())
+	options, err := reply.Options()
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpAck, options.MessageType())
+	assert.Equal(t, []byte(siteServerIP), options[OptionServerIdentifier])
+	assert.Equal(t, []byte{255, 255, 255, 0}, options[OptionSubnetMask])
+	assert.Equal(t, []byte{10, 20, 30, 1}, options[OptionRouter])
+	assert.Equal(t, []byte{10, 20, 30, 2}, options[OptionDomainNameServer])
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, options[OptionIPLeaseTime])
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+	assert.NotContains(t, options, OptionTimeOffset)
+}
+
+func TestHandleProxyRecordsLease(t *testing.T) {
+	s := newTestProxy(t)
+
+	_, err := s.handleProxy(newTestSiteAck(), net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	lease, ok := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.True(t, ok)
+	assert.Equal(t, net.IPv4(10, 20, 30, 77).To4(), lease.IP)
+	assert.Equal(t, "test.com", lease.DomainName)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), lease.Expiry, time.Minute)
+}
+
+func TestHandleProxyReservation(t *testing.T) {
+	s := newTestProxy(t)
+	path := writeReservations(t, "reservations:\n  - mac: 00:11:22:33:44:55\n    domainName: site2.com\n")
+	s.cfg.Reservations, _ = LoadReservations(path)
+
+	reply, err := s.handleProxy(newTestSiteAck(), net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte("site2.com"), options[OptionDomainName])
+}
+
+func TestHandleProxyReservationUUID(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	s, err := NewServer(cfg)
+	assert.NoError(t, err)
+	path := writeReservations(t, "reservations:\n  - uuid: 8e2a5d1c-0b3f-4c6a-9d7e-112233445566\n    domainName: site2.com\n")
+	s.cfg.Reservations, _ = LoadReservations(path)
+	// not an AMT request, only the reservation of its option 97 UUID
+	// gets it the suffix; the ACK carries no option 97
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionClientMachineID, newTestMachineID())
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.snoopRequest(req, serverIP)
+
+	reply, err := s.handleProxy(newTestSiteAck(), serverIP)
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, reply) {
+		options, _ := reply.Options()
+		assert.Equal(t, []byte("site2.com"), options[OptionDomainName])
+	}
+}
+
+func TestHandleProxyIgnored(t *testing.T) {
+	s := newTestProxy(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	injected, _ := s.handleProxy(newTestSiteAck(), serverIP)
+	offer, _ := createReplyPacket(newTestRequest(dhcpDiscover), dhcpOffer, siteServerIP, net.IPv4(10, 20, 30, 77), nil)
+	own, _ := createReplyPacket(newTestRequest(dhcpRequest), dhcpAck, serverIP, net.IPv4(10, 20, 30, 77), nil)
+	inform, _ := createReplyPacket(newTestRequest(dhcpInform), dhcpAck, siteServerIP, net.IPv4zero, nil)
+
+	for _, observed := range []Packet{newTestRequest(dhcpRequest), offer, own, inform, injected} {
+		reply, err := s.handleProxy(observed, serverIP)
+		assert.NoError(t, err)
+		assert.Nil(t, reply)
+	}
+}
+
+func TestHandleProxyNotAMT(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	s, _ := NewServer(cfg)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	_, err := s.snoopRequest(newTestRequest(dhcpRequest), serverIP)
+	assert.NoError(t, err)
+	reply, err := s.handleProxy(newTestSiteAck(), serverIP)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Empty(t, s.Leases())
+	assert.Len(t, s.Snooped(), 1)
+}
+
+func TestHandleProxyClasses(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	cfg.Classes = newTestClasses()
+	s, _ := NewServer(cfg)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionClientMachineID, newTestMachineID())
+
+	_, err := s.snoopRequest(req, serverIP)
+	assert.NoError(t, err)
+	reply, err := s.handleProxy(newTestSiteAck(), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte("lab.example.com"), options[OptionDomainName])
+	assert.Equal(t, "lab", s.Snooped()[0].Class)
+
+	// denied classes are never injected into
+	s, _ = NewServer(cfg)
+	req = newTestRequest(dhcpRequest)
+	req.AddOption(OptionVendorClassIdentifier, []byte("Hewlett-Packard"))
+	s.snoopRequest(req, serverIP)
+	reply, err = s.handleProxy(newTestSiteAck(), serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+}
+
+func TestHandleProxyOncePerLease(t *testing.T) {
+	s := newTestProxy(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, _ := s.handleProxy(newTestSiteAck(), serverIP)
+	assert.NotNil(t, reply)
+	reply, _ = s.handleProxy(newTestSiteAck(), serverIP)
+	assert.Nil(t, reply)
+	assert.True(t, s.Snooped()[0].Injected)
+}
+
+func TestNewServerProxy(t *testing.T) {
+	s := newTestProxy(t)
+	assert.Equal(t, ":68", s.Config().ProxyListenAddr)
+
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	cfg.Pool, _ = NewPool(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20), nil)
+	_, err := NewServer(cfg)
+	assert.Error(t, err)
+}
+
+func TestServerRunProxy(t *testing.T) {
+	s := newTestProxy(t)
+	s.cfg.ProxyListenAddr = "127.0.0.1:0"
+	s.cfg.ListenAddr = "127.0.0.1:0"
+	s.cfg.Enumerator = newMockEnumerator()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run(ctx) }()
+	assert.Eventually(t, func() bool { return s.Status().Listening }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-errs)
+}
@@ -0,0 +1,204 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rawConn sends and receives UDP over an AF_PACKET socket, building the
+// Ethernet, IPv4 and UDP headers itself.  Unicast replies go to the chaddr
+// of the DHCP message, so clients without an address or ARP entry can be
+// reached the way DHCP servers usually do.  Replies through a relay are sent
+// to the hardware address the relay sent from.
+type rawConn struct {
+	file      *os.File
+	conn      syscall.RawConn
+	ifindex   int
+	mac       net.HardwareAddr
+	addr      *net.UDPAddr
+	broadcast net.IP
+	neighbors neighborCache
+
+	// readMu guards buffer, frames are read into it whole
+	readMu sync.Mutex
+	buffer []byte
+}
+
+// rawBufferLen holds the largest IPv4 datagram with its Ethernet header
+const rawBufferLen = 65536 + etherHeaderLen
+
+// udpPortFilter returns a BPF program passing the unfragmented IPv4 UDP
+// datagrams sent to port, so the socket is not woken for all other traffic
+func udpPortFilter(port int) []syscall.SockFilter {
+	return []syscall.SockFilter{
+		// EtherType IPv4
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 12),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.ETH_P_IP, 0, 8),
+		// protocol UDP
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, etherHeaderLen+9),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.IPPROTO_UDP, 0, 6),
+		// no fragment offset
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, etherHeaderLen+6),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, 0x1fff, 4, 0),
+		// destination port, after the IP header and its options
+		*syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_B|syscall.BPF_MSH, etherHeaderLen),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, etherHeaderLen+2),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, port, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, rawBufferLen),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+	}
+}
+
+// ListenRaw opens a raw socket on iface receiving the UDP datagrams sent to
+// port.  Like ICMPProber it needs root or CAP_NET_RAW.
+func ListenRaw(iface NetworkInterface, port int) (net.PacketConn, error) {
+	ifi, err := net.InterfaceByIndex(iface.Index)
+	if err != nil {
+		return nil, err
+	}
+	mac := ifi.HardwareAddr
+	if len(mac) == 0 {
+		// loopback has no hardware address but still takes Ethernet headers
+		mac = make(net.HardwareAddr, 6)
+	}
+	if len(mac) != 6 {
+		return nil, errors.New("interface " + ifi.Name + " is not Ethernet")
+	}
+	// the socket receives nothing until bound, so the filter applies to
+	// every frame queued
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.AttachLsf(fd, udpPortFilter(port)); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_IP), Ifindex: ifi.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// a non-blocking file is served by the runtime poller, so Close and
+	// deadlines interrupt pending reads
+	file := os.NewFile(uintptr(fd), "packet:"+ifi.Name)
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rawConn{
+		file:      file,
+		conn:      conn,
+		ifindex:   ifi.Index,
+		mac:       mac,
+		addr:      &net.UDPAddr{IP: iface.Address.IP, Port: port},
+		broadcast: directedBroadcast(iface.Address),
+		buffer:    make([]byte, rawBufferLen),
+	}, nil
+}
+
+// ReadFrom returns the payload of the next UDP datagram sent to the port
+func (c *rawConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	buffer := c.buffer
+	for {
+		var n int
+		var from syscall.Sockaddr
+		var recvErr error
+		err := c.conn.Read(func(fd uintptr) bool {
+			n, from, recvErr = syscall.Recvfrom(int(fd), buffer, 0)
+			return recvErr != syscall.EAGAIN
+		})
+		if err == nil {
+			err = recvErr
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		// frames this socket sends are looped back to it
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		frame, err := parseUDPFrame(buffer[:n])
+		if err != nil || int(frame.DstPort) != c.addr.Port {
+			continue
+		}
+		c.neighbors.learn(frame)
+		return copy(b, frame.Payload), &net.UDPAddr{IP: frame.SrcIP, Port: int(frame.SrcPort)}, nil
+	}
+}
+
+// WriteTo sends b to addr.  Broadcasts use the Ethernet broadcast address,
+// DHCP messages to a relay the hardware address the relay sent from and
+// any other DHCP message the client hardware address.
+func (c *rawConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || udpAddr.IP.To4() == nil {
+		return 0, errors.New("raw socket needs an IPv4 UDP address")
+	}
+	dstMAC := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if !udpAddr.IP.Equal(net.IPv4bcast) && !udpAddr.IP.Equal(c.broadcast) {
+		pkt := Packet(b)
+		switch {
+		case len(pkt) < 240:
+			return 0, errors.New("raw socket can only unicast DHCP messages")
+		case !pkt.GIAddr().Equal(net.IPv4zero):
+			mac, ok := c.neighbors.lookup(udpAddr.IP)
+			if !ok {
+				return 0, fmt.Errorf("no hardware address known for relay %s", udpAddr.IP)
+			}
+			dstMAC = mac
+		case pkt.HLen() != 6:
+			return 0, errors.New("raw socket can only unicast to Ethernet clients")
+		default:
+			dstMAC = pkt.CHAddr()
+		}
+	}
+	frame, err := udpFrame{
+		SrcMAC:  c.mac,
+		DstMAC:  dstMAC,
+		SrcIP:   c.addr.IP,
+		DstIP:   udpAddr.IP,
+		SrcPort: uint16(c.addr.Port),
+		DstPort: uint16(udpAddr.Port),
+		Payload: b,
+	}.marshal()
+	if err != nil {
+		return 0, err
+	}
+	to := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_IP), Ifindex: c.ifindex, Halen: 6}
+	copy(to.Addr[:], dstMAC)
+	var sendErr error
+	err = c.conn.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendto(int(fd), frame, 0, to)
+		return sendErr != syscall.EAGAIN
+	})
+	if err == nil {
+		err = sendErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *rawConn) Close() error                       { return c.file.Close() }
+func (c *rawConn) LocalAddr() net.Addr                { return c.addr }
+func (c *rawConn) SetDeadline(t time.Time) error      { return c.file.SetDeadline(t) }
+func (c *rawConn) SetReadDeadline(t time.Time) error  { return c.file.SetReadDeadline(t) }
+func (c *rawConn) SetWriteDeadline(t time.Time) error { return c.file.SetWriteDeadline(t) }
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
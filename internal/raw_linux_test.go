@@ -0,0 +1,128 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listenTestRaw(t *testing.T, port int) net.PacketConn {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface: ", err)
+	}
+	iface := NetworkInterface{Name: lo.Name, Index: lo.Index, Address: &net.IPNet{IP: net.IPv4(127, 0, 0, 1).To4(), Mask: net.CIDRMask(8, 32)}}
+	conn, err := ListenRaw(iface, port)
+	if err != nil {
+		t.Skip("raw sockets not permitted: ", err)
+	}
+	return conn
+}
+
+func TestRawConnReadFrom(t *testing.T) {
+	conn := listenTestRaw(t, 16767)
+	defer conn.Close()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	client, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 16767})
+	assert.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("discover"))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 1500)
+	n, from, err := conn.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "discover", string(buffer[:n]))
+	assert.Equal(t, client.LocalAddr().String(), from.String())
+}
+
+func TestRawConnWriteTo(t *testing.T) {
+	conn := listenTestRaw(t, 16768)
+	defer conn.Close()
+	// the IP stack drops frames injected on loopback as martians, so the
+	// reply is read back from a second raw socket
+	peer := listenTestRaw(t, 16769)
+	defer peer.Close()
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	reply := newTestRequest(dhcpAck)
+	_, err := conn.WriteTo(reply, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 16769})
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 1500)
+	n, from, err := peer.ReadFrom(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []byte(reply), buffer[:n])
+	assert.Equal(t, "127.0.0.1:16768", from.String())
+}
+
+func TestListenRawTransport(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface: ", err)
+	}
+	iface := NetworkInterface{Name: lo.Name, Index: lo.Index, Address: &net.IPNet{IP: net.IPv4(127, 0, 0, 1).To4(), Mask: net.CIDRMask(8, 32)}}
+	conn, err := ListenRawTransport(context.Background(), iface, ":16772")
+	if err != nil {
+		t.Skip("raw sockets not permitted: ", err)
+	}
+
+	// the port is held, so unicasts to it are not refused
+	_, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 16772})
+	assert.Error(t, err)
+	// transports opened to send share it
+	sender, err := ListenRawTransport(context.Background(), iface, ":16772")
+	assert.NoError(t, err)
+	sender.Close()
+
+	conn.Close()
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 16772})
+	assert.NoError(t, err)
+	udp.Close()
+}
+
+func TestRawConnClose(t *testing.T) {
+	conn := listenTestRaw(t, 16770)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 1500))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read not interrupted by close")
+	}
+}
+
+func TestRawConnWriteToErrors(t *testing.T) {
+	conn := listenTestRaw(t, 16771)
+	defer conn.Close()
+
+	_, err := conn.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 16769})
+	assert.Error(t, err)
+	_, err = conn.WriteTo(newTestRequest(dhcpAck), &net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Error(t, err)
+	// no request came through the relay
+	relayed := newTestRequest(dhcpAck)
+	relayed.SetGIAddr(net.IPv4(127, 0, 0, 2))
+	_, err = conn.WriteTo(relayed, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 16769})
+	assert.Error(t, err)
+}
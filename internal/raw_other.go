@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"net"
+)
+
+// ListenRaw is only available on Linux, where AF_PACKET sockets exist
+func ListenRaw(iface NetworkInterface, port int) (net.PacketConn, error) {
+	return nil, errors.New("raw sockets not supported on this platform")
+}
@@ -0,0 +1,167 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Sub-options of the relay agent information option
+const (
+	relayCircuitID     = 1 // RFC 3046
+	relayRemoteID      = 2 // RFC 3046
+	relayLinkSelection = 5 // RFC 3527
+	relaySubscriberID  = 6 // RFC 3993
+)
+
+// RelayAgentInfo holds the sub-options of option 82 a relay added to a
+// request.  Circuit IDs usually name the switch port the client is on and
+// remote IDs the switch itself.
+type RelayAgentInfo struct {
+	CircuitID     []byte
+	RemoteID      []byte
+	LinkSelection net.IP
+	SubscriberID  []byte
+}
+
+// ParseRelayAgentInfo decodes the value of option 82.  Unknown sub-options
+// are skipped.
+func ParseRelayAgentInfo(b []byte) (RelayAgentInfo, error) {
+	var info RelayAgentInfo
+	for i := 0; i < len(b); {
+		if i+2 > len(b) || i+2+int(b[i+1]) > len(b) {
+			return info, fmt.Errorf("relay agent information sub-option %d: %w", b[i], ErrOptionTruncated)
+		}
+		code, value := b[i], b[i+2:i+2+int(b[i+1])]
+		switch code {
+		case relayCircuitID:
+			info.CircuitID = value
+		case relayRemoteID:
+			info.RemoteID = value
+		case relayLinkSelection:
+			if len(value) != net.IPv4len {
+				return info, fmt.Errorf("relay agent link selection of %d bytes", len(value))
+			}
+			info.LinkSelection = net.IP(value)
+		case relaySubscriberID:
+			info.SubscriberID = value
+		}
+		i += 2 + len(value)
+	}
+	return info, nil
+}
+
+// RelayRule applies to relayed requests whose relay agent information
+// matches every ID the rule sets; a rule without IDs matches all of them.
+// Matching requests are ignored when Deny is set, otherwise they are served
+// from Scope and DomainName when those are set.
+type RelayRule struct {
+	CircuitID    []byte
+	RemoteID     []byte
+	SubscriberID []byte
+	Deny         bool
+	DomainName   string
+	Scope        *Scope
+}
+
+type relayRuleConfig struct {
+	CircuitID    string `yaml:"circuitId"`
+	RemoteID     string `yaml:"remoteId"`
+	SubscriberID string `yaml:"subscriberId"`
+	Action       string `yaml:"action"` // allow, the default, or deny
+	DomainName   string `yaml:"domainName"`
+	Scope        string `yaml:"scope"` // name of the scope addresses come from
+}
+
+func (cfg relayRuleConfig) rule(scopes []*Scope) (RelayRule, error) {
+	var rule RelayRule
+	var err error
+	if rule.CircuitID, err = parseRelayID(cfg.CircuitID); err != nil {
+		return rule, err
+	}
+	if rule.RemoteID, err = parseRelayID(cfg.RemoteID); err != nil {
+		return rule, err
+	}
+	if rule.SubscriberID, err = parseRelayID(cfg.SubscriberID); err != nil {
+		return rule, err
+	}
+	switch cfg.Action {
+	case "", "allow":
+	case "deny":
+		rule.Deny = true
+	default:
+		return rule, fmt.Errorf("invalid action %q", cfg.Action)
+	}
+	if cfg.DomainName != "" {
+		if rule.DomainName, err = NormalizeDomain(cfg.DomainName); err != nil {
+			return rule, err
+		}
+	}
+	if cfg.Scope != "" {
+		for _, scope := range scopes {
+			if scope.Name == cfg.Scope {
+				rule.Scope = scope
+			}
+		}
+		if rule.Scope == nil {
+			return rule, fmt.Errorf("unknown scope %q", cfg.Scope)
+		}
+	}
+	return rule, nil
+}
+
+// parseRelayID reads an ID as text, or as hex when prefixed with 0x as
+// binary circuit IDs often are
+func parseRelayID(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "0x") {
+		id, err := hex.DecodeString(s[2:])
+		if err != nil || len(id) == 0 {
+			return nil, fmt.Errorf("invalid hex id %q", s)
+		}
+		return id, nil
+	}
+	if len(s) > maxOptionLen-2 {
+		return nil, errors.New("relay agent id longer than a sub-option")
+	}
+	return []byte(s), nil
+}
+
+func (rule RelayRule) matches(info RelayAgentInfo) bool {
+	return (rule.CircuitID == nil || bytes.Equal(rule.CircuitID, info.CircuitID)) &&
+		(rule.RemoteID == nil || bytes.Equal(rule.RemoteID, info.RemoteID)) &&
+		(rule.SubscriberID == nil || bytes.Equal(rule.SubscriberID, info.SubscriberID))
+}
+
+// matchRelayRule returns the first rule matching info
+func matchRelayRule(rules []RelayRule, info RelayAgentInfo) (RelayRule, bool) {
+	for _, rule := range rules {
+		if rule.matches(info) {
+			return rule, true
+		}
+	}
+	return RelayRule{}, false
+}
+
+// options returns the reply options overridden by the rule.  The domain
+// name was checked when the rule was loaded.
+func (rule RelayRule) options() []Option {
+	var opts []Option
+	if rule.Scope != nil {
+		opts = rule.Scope.options()
+	}
+	if rule.DomainName != "" {
+		opt, _ := OptionDomain(OptionDomainName, rule.DomainName)
+		opts = mergeOptions(opts, []Option{opt})
+	}
+	return opts
+}
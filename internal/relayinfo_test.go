@@ -0,0 +1,84 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelayAgentInfo(t *testing.T) {
+	info, err := ParseRelayAgentInfo([]byte{
+		relayCircuitID, 3, 'p', '1', '2',
+		relayRemoteID, 2, 's', '3',
+		9, 1, 0, // vendor specific, skipped
+		relayLinkSelection, 4, 10, 60, 0, 0,
+		relaySubscriberID, 1, 'x',
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, RelayAgentInfo{
+		CircuitID:     []byte("p12"),
+		RemoteID:      []byte("s3"),
+		LinkSelection: net.IP{10, 60, 0, 0},
+		SubscriberID:  []byte("x"),
+	}, info)
+}
+
+func TestParseRelayAgentInfoEmpty(t *testing.T) {
+	info, err := ParseRelayAgentInfo(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, RelayAgentInfo{}, info)
+}
+
+func TestParseRelayAgentInfoErrors(t *testing.T) {
+	for _, b := range [][]byte{
+		{relayCircuitID},
+		{relayCircuitID, 3, 'p'},
+		{relayLinkSelection, 4, 10, 60},
+		{relayLinkSelection, 3, 10, 60, 0},
+	} {
+		_, err := ParseRelayAgentInfo(b)
+		assert.Error(t, err, b)
+	}
+	_, err := ParseRelayAgentInfo([]byte{relayCircuitID, 3, 'p'})
+	assert.ErrorIs(t, err, ErrOptionTruncated)
+}
+
+func TestMatchRelayRule(t *testing.T) {
+	rules := []RelayRule{
+		{CircuitID: []byte("p12"), RemoteID: []byte("s3"), DomainName: "floor3.example.com"},
+		{RemoteID: []byte("guest"), Deny: true},
+		{SubscriberID: []byte("x")},
+	}
+
+	rule, ok := matchRelayRule(rules, RelayAgentInfo{CircuitID: []byte("p12"), RemoteID: []byte("s3")})
+	assert.True(t, ok)
+	assert.Equal(t, "floor3.example.com", rule.DomainName)
+
+	rule, ok = matchRelayRule(rules, RelayAgentInfo{CircuitID: []byte("p12"), RemoteID: []byte("guest")})
+	assert.True(t, ok)
+	assert.True(t, rule.Deny)
+
+	_, ok = matchRelayRule(rules, RelayAgentInfo{CircuitID: []byte("p12")})
+	assert.False(t, ok)
+
+	_, ok = matchRelayRule(append(rules, RelayRule{}), RelayAgentInfo{})
+	assert.True(t, ok)
+}
+
+func TestRelayRuleOptions(t *testing.T) {
+	cfg, _ := parseConfigFile([]byte(testScopes))
+	rule := RelayRule{Scope: cfg.Scopes[1], DomainName: "floor3.example.com"}
+
+	assert.Equal(t, []Option{
+		{Code: OptionSubnetMask, Value: []byte{255, 255, 0, 0}},
+		{Code: OptionDomainName, Value: []byte("floor3.example.com")},
+	}, rule.options())
+	assert.Empty(t, RelayRule{}.options())
+}
@@ -0,0 +1,364 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reservation fixes the address and configuration handed to one device,
+// identified by its MAC address and/or the AMT UUID it sends in option 97.
+// Empty fields fall back to the server defaults.
+type Reservation struct {
+	MAC        string   `yaml:"mac" json:"mac,omitempty"`
+	UUID       string   `yaml:"uuid" json:"uuid,omitempty"`
+	IP         string   `yaml:"ip" json:"ip,omitempty"`
+	SubnetMask string   `yaml:"subnetMask" json:"subnetMask,omitempty"`
+	Router     string   `yaml:"router" json:"router,omitempty"`
+	DNSServers []string `yaml:"dnsServers" json:"dnsServers,omitempty"`
+	DomainName string   `yaml:"domainName" json:"domainName,omitempty"`
+}
+
+type reservationFile struct {
+	Reservations []Reservation `yaml:"reservations"`
+}
+
+// Reservations is the set of per-device reservations read from a YAML or
+// JSON file.  It is safe for concurrent use and can be reloaded while in use.
+type Reservations struct {
+	// CheckDomain, when set, vets the domain names of reloaded
+	// reservations; a reload it returns an error for is rejected
+	CheckDomain func(domainName string) error
+
+	path    string
+	mu      sync.RWMutex
+	modTime time.Time
+	size    int64
+	byMAC   map[string]Reservation
+	byUUID  map[string]Reservation
+}
+
+// LoadReservations reads the reservations file at path.
+func LoadReservations(path string) (*Reservations, error) {
+	r := &Reservations{path: path}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the file if it changed since it was last read, and reports
+// whether it did.  The current reservations are kept when the file is invalid.
+func (r *Reservations) Reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := info.ModTime().Equal(r.modTime) && info.Size() == r.size
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, err
+	}
+	byMAC, byUUID, err := parseReservations(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.path, err)
+	}
+	if r.CheckDomain != nil {
+		for _, domainName := range reservationDomains(byMAC, byUUID) {
+			if err := r.CheckDomain(domainName); err != nil {
+				return false, fmt.Errorf("%s: %w", r.path, err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.byMAC = byMAC
+	r.byUUID = byUUID
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch polls the file every interval and reloads it on change until ctx is
+// cancelled.
+func (r *Reservations) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Println("Error reloading reservations: ", err)
+			} else if reloaded {
+				log.Println("Reloaded reservations from ", r.path)
+			}
+		}
+	}
+}
+
+// Lookup returns the reservation for a client, matching the UUID first as it
+// survives NIC changes, then the MAC address.
+func (r *Reservations) Lookup(mac net.HardwareAddr, uuid string) (Reservation, bool) {
+	if r == nil {
+		return Reservation{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if uuid != "" {
+		if res, ok := r.byUUID[strings.ToLower(uuid)]; ok {
+			return res, true
+		}
+	}
+	res, ok := r.byMAC[mac.String()]
+	return res, ok
+}
+
+// ReservesIP reports whether ip is reserved for any device
+func (r *Reservations) ReservesIP(ip net.IP) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, byKey := range []map[string]Reservation{r.byMAC, r.byUUID} {
+		for _, res := range byKey {
+			if net.ParseIP(res.IP).Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Path returns the file the reservations are read from
+func (r *Reservations) Path() string {
+	if r == nil {
+		return ""
+	}
+	return r.path
+}
+
+// Len returns the number of reservations loaded
+func (r *Reservations) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := len(r.byMAC)
+	for _, res := range r.byUUID {
+		if res.MAC == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// DomainNames returns the distinct domain names the reservations set
+func (r *Reservations) DomainNames() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return reservationDomains(r.byMAC, r.byUUID)
+}
+
+func reservationDomains(byKeys ...map[string]Reservation) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, byKey := range byKeys {
+		for _, res := range byKey {
+			if res.DomainName != "" && !seen[res.DomainName] {
+				seen[res.DomainName] = true
+				domains = append(domains, res.DomainName)
+			}
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func parseReservations(data []byte) (map[string]Reservation, map[string]Reservation, error) {
+	// YAML is a superset of JSON, so this reads either format
+	var file reservationFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, err
+	}
+
+	byMAC := make(map[string]Reservation)
+	byUUID := make(map[string]Reservation)
+	// entry numbers by key, a second entry for a client would shadow the first
+	macEntry := make(map[string]int)
+	uuidEntry := make(map[string]int)
+	for i, res := range file.Reservations {
+		if err := res.validate(); err != nil {
+			return nil, nil, fmt.Errorf("reservation %d: %w", i+1, err)
+		}
+		if res.DomainName != "" {
+			res.DomainName, _ = NormalizeDomain(res.DomainName)
+		}
+		// an entry naming both is found by either, clients do not all
+		// send option 97
+		if res.MAC != "" {
+			mac, _ := net.ParseMAC(res.MAC)
+			res.MAC = mac.String()
+		}
+		if res.UUID != "" {
+			res.UUID = strings.ToLower(res.UUID)
+			if n, ok := uuidEntry[res.UUID]; ok {
+				return nil, nil, fmt.Errorf("reservation %d: duplicate uuid %s (also reservation %d)", i+1, res.UUID, n)
+			}
+			uuidEntry[res.UUID] = i + 1
+			byUUID[res.UUID] = res
+		}
+		if res.MAC != "" {
+			if n, ok := macEntry[res.MAC]; ok {
+				return nil, nil, fmt.Errorf("reservation %d: duplicate mac %s (also reservation %d)", i+1, res.MAC, n)
+			}
+			macEntry[res.MAC] = i + 1
+			byMAC[res.MAC] = res
+		}
+	}
+	return byMAC, byUUID, nil
+}
+
+func (res Reservation) validate() error {
+	if res.MAC == "" && res.UUID == "" {
+		return errors.New("mac or uuid is required")
+	}
+	if res.MAC != "" {
+		if _, err := net.ParseMAC(res.MAC); err != nil {
+			return err
+		}
+	}
+	if res.UUID != "" && !validUUID(res.UUID) {
+		return fmt.Errorf("invalid uuid %q", res.UUID)
+	}
+	for _, ip := range append([]string{res.IP, res.SubnetMask, res.Router}, res.DNSServers...) {
+		if ip != "" && net.ParseIP(ip).To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %q", ip)
+		}
+	}
+	if res.DomainName != "" {
+		if _, err := NormalizeDomain(res.DomainName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options returns the reply options overridden by the reservation.  The
+// addresses and domain name were checked when the file was loaded.
+func (res Reservation) options() []Option {
+	var opts []Option
+	if res.SubnetMask != "" {
+		opt, _ := OptionIPList(OptionSubnetMask, net.ParseIP(res.SubnetMask))
+		opts = append(opts, opt)
+	}
+	if res.Router != "" {
+		opt, _ := OptionIPList(OptionRouter, net.ParseIP(res.Router))
+		opts = append(opts, opt)
+	}
+	if len(res.DNSServers) > 0 {
+		var servers []net.IP
+		for _, dns := range res.DNSServers {
+			servers = append(servers, net.ParseIP(dns))
+		}
+		if opt, err := OptionIPList(OptionDomainNameServer, servers...); err == nil {
+			opts = append(opts, opt)
+		}
+	}
+	if res.DomainName != "" {
+		if opt, err := OptionDomain(OptionDomainName, res.DomainName); err == nil {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// withoutOption returns opts less the options with code
+func withoutOption(opts []Option, code OptionCode) []Option {
+	var kept []Option
+	for _, opt := range opts {
+		if opt.Code != code {
+			kept = append(kept, opt)
+		}
+	}
+	return kept
+}
+
+// mergeOptions replaces options in base with those of the same code in
+// overrides, appending any that base does not have.
+func mergeOptions(base []Option, overrides []Option) []Option {
+	merged := append([]Option(nil), base...)
+	for _, override := range overrides {
+		replaced := false
+		for i := range merged {
+			if merged[i].Code == override.Code {
+				merged[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// clientUUID formats the machine UUID sent in option 97.  The first three
+// fields are little endian, following the SMBIOS encoding that AMT reports.
+func clientUUID(options Options) string {
+	id := options[OptionClientMachineID]
+	if len(id) != 17 || id[0] != 0 {
+		return ""
+	}
+	u := id[1:]
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%s-%s",
+		u[3], u[2], u[1], u[0], u[5], u[4], u[7], u[6],
+		hex.EncodeToString(u[8:10]), hex.EncodeToString(u[10:16]))
+}
+
+func validUUID(uuid string) bool {
+	if len(uuid) != 36 {
+		return false
+	}
+	for i, c := range uuid {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testReservations = `
+reservations:
+  - mac: 00-11-22-33-44-55
+    ip: 10.20.30.40
+    subnetMask: 255.255.0.0
+    router: 10.20.0.1
+    dnsServers: [10.20.0.2, 10.20.0.3]
+    domainName: site1.example.com
+  - uuid: 4C4C4544-0048-4A10-8056-B4C04F4D4D32
+    ip: 10.20.30.41
+    domainName: site2.example.com
+`
+
+func writeReservations(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "reservations.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadReservations(t *testing.T) {
+	r, err := LoadReservations(writeReservations(t, testReservations))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Len())
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	res, ok := r.Lookup(mac, "")
+	assert.True(t, ok)
+	assert.Equal(t, "10.20.30.40", res.IP)
+	assert.Equal(t, "site1.example.com", res.DomainName)
+
+	res, ok = r.Lookup(mac, "4c4c4544-0048-4a10-8056-b4c04f4d4d32")
+	assert.True(t, ok)
+	assert.Equal(t, "site2.example.com", res.DomainName)
+}
+
+func TestLoadReservationsJSON(t *testing.T) {
+	path := writeReservations(t, `{"reservations": [{"mac": "00:11:22:33:44:55", "domainName": "json.example.com"}]}`)
+
+	r, err := LoadReservations(path)
+
+	assert.NoError(t, err)
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	res, ok := r.Lookup(mac, "")
+	assert.True(t, ok)
+	assert.Equal(t, "json.example.com", res.DomainName)
+}
+
+func TestLoadReservationsInvalid(t *testing.T) {
+	for _, content := range []string{
+		"reservations: [{ip: 10.0.0.1}]",
+		"reservations: [{mac: zz:11:22:33:44:55}]",
+		"reservations: [{uuid: not-a-uuid}]",
+		"reservations: [{mac: 00:11:22:33:44:55, ip: 10.0.0}]",
+		"reservations: [{mac: 00:11:22:33:44:55, domainName: 'site 1.example.com'}]",
+		"reservations: {",
+	} {
+		_, err := LoadReservations(writeReservations(t, content))
+		assert.Error(t, err, content)
+	}
+	_, err := LoadReservations(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestParseReservationsDuplicate(t *testing.T) {
+	_, _, err := parseReservations([]byte(`
+reservations:
+  - mac: 00-11-22-33-44-55
+    ip: 10.20.30.40
+  - uuid: 4C4C4544-0048-4A10-8056-B4C04F4D4D32
+  - mac: 00:11:22:33:44:55
+    ip: 10.20.30.41
+`))
+	assert.EqualError(t, err, "reservation 3: duplicate mac 00:11:22:33:44:55 (also reservation 1)")
+
+	_, _, err = parseReservations([]byte(`
+reservations:
+  - mac: 00-11-22-33-44-55
+    uuid: 4C4C4544-0048-4A10-8056-B4C04F4D4D32
+  - uuid: 4c4c4544-0048-4a10-8056-b4c04f4d4d32
+`))
+	assert.EqualError(t, err, "reservation 2: duplicate uuid 4c4c4544-0048-4a10-8056-b4c04f4d4d32 (also reservation 1)")
+}
+
+func TestLoadReservationsMACAndUUID(t *testing.T) {
+	r, err := LoadReservations(writeReservations(t, `
+reservations:
+  - mac: 00-11-22-33-44-55
+    uuid: 4C4C4544-0048-4A10-8056-B4C04F4D4D32
+    domainName: both.example.com
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Len())
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	res, ok := r.Lookup(mac, "")
+	assert.True(t, ok)
+	assert.Equal(t, "both.example.com", res.DomainName)
+	other, _ := net.ParseMAC("00:11:22:33:44:66")
+	res, ok = r.Lookup(other, "4c4c4544-0048-4a10-8056-b4c04f4d4d32")
+	assert.True(t, ok)
+	assert.Equal(t, "both.example.com", res.DomainName)
+}
+
+func TestReservationsLookupMissing(t *testing.T) {
+	var r *Reservations
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+
+	_, ok := r.Lookup(mac, "")
+
+	assert.False(t, ok)
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestReservationsReload(t *testing.T) {
+	path := writeReservations(t, testReservations)
+	r, _ := LoadReservations(path)
+
+	reloaded, err := r.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	assert.NoError(t, os.WriteFile(path, []byte("reservations: [{mac: 00:11:22:33:44:66}]"), 0600))
+	reloaded, err = r.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, 1, r.Len())
+
+	// a broken file keeps the last good reservations
+	assert.NoError(t, os.WriteFile(path, []byte("reservations: {"), 0600))
+	_, err = r.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, 1, r.Len())
+
+	// so does one reserving a client twice
+	assert.NoError(t, os.WriteFile(path, []byte("reservations: [{mac: 00:11:22:33:44:77}, {mac: 00-11-22-33-44-77}]"), 0600))
+	_, err = r.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, 1, r.Len())
+	mac, _ := net.ParseMAC("00:11:22:33:44:66")
+	_, ok := r.Lookup(mac, "")
+	assert.True(t, ok)
+}
+
+func TestReservationsWatch(t *testing.T) {
+	path := writeReservations(t, testReservations)
+	r, _ := LoadReservations(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(path, []byte("reservations: []"), 0600))
+
+	assert.Eventually(t, func() bool { return r.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReservationOptions(t *testing.T) {
+	res := Reservation{SubnetMask: "255.255.0.0", Router: "10.0.0.1", DNSServers: []string{"10.0.0.2", "10.0.0.3"}, DomainName: "a.com"}
+
+	rcvd := res.options()
+
+	assert.Equal(t, []Option{
+		{Code: OptionSubnetMask, Value: []byte{255, 255, 0, 0}},
+		{Code: OptionRouter, Value: []byte{10, 0, 0, 1}},
+		{Code: OptionDomainNameServer, Value: []byte{10, 0, 0, 2, 10, 0, 0, 3}},
+		{Code: OptionDomainName, Value: []byte("a.com")},
+	}, rcvd)
+}
+
+func TestMergeOptions(t *testing.T) {
+	base := []Option{{Code: OptionSubnetMask, Value: []byte{255, 255, 255, 0}}, {Code: OptionDomainName, Value: []byte("a.com")}}
+	overrides := []Option{{Code: OptionDomainName, Value: []byte("b.com")}, {Code: OptionRouter, Value: []byte{10, 0, 0, 1}}}
+
+	rcvd := mergeOptions(base, overrides)
+
+	assert.Equal(t, []Option{
+		{Code: OptionSubnetMask, Value: []byte{255, 255, 255, 0}},
+		{Code: OptionDomainName, Value: []byte("b.com")},
+		{Code: OptionRouter, Value: []byte{10, 0, 0, 1}},
+	}, rcvd)
+	assert.Equal(t, []byte("a.com"), base[1].Value)
+}
+
+func TestClientUUID(t *testing.T) {
+	id := []byte{0, 0x44, 0x45, 0x4c, 0x4c, 0x48, 0x00, 0x10, 0x4a, 0x80, 0x56, 0xb4, 0xc0, 0x4f, 0x4d, 0x4d, 0x32}
+
+	assert.Equal(t, "4c4c4544-0048-4a10-8056-b4c04f4d4d32", clientUUID(Options{OptionClientMachineID: id}))
+	assert.Equal(t, "", clientUUID(Options{OptionClientMachineID: id[1:]}))
+	assert.Equal(t, "", clientUUID(Options{}))
+}
+
+func TestHandleRequestReservation(t *testing.T) {
+	r, _ := LoadReservations(writeReservations(t, testReservations))
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s := newTestServer(t)
+	s.cfg.Reservations = r
+
+	reply, err := s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.40"), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, "10.20.30.40", reply.YIAddr().String())
+	assert.Equal(t, []byte{255, 255, 0, 0}, options[OptionSubnetMask])
+	assert.Equal(t, []byte{10, 20, 0, 1}, options[OptionRouter])
+	assert.Equal(t, []byte("site1.example.com"), options[OptionDomainName])
+}
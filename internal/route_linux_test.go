@@ -0,0 +1,33 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRouteTable = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wlp2s0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+enp3s0	00000000	011E140A	0003	0	0	100	00000000	0	0	0
+enp3s0	001E140A	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`
+
+func TestParseRouteTable(t *testing.T) {
+	name, err := parseRouteTable(strings.NewReader(testRouteTable))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "enp3s0", name)
+}
+
+func TestParseRouteTableNoDefault(t *testing.T) {
+	table := "Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT\n"
+
+	_, err := parseRouteTable(strings.NewReader(table))
+
+	assert.Error(t, err)
+}
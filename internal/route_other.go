@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"syscall"
+)
+
+func defaultRouteInterface() (string, error) {
+	return "", errors.New("default route lookup not supported on this platform")
+}
+
+// reuseAddr is a no-op where ports are not shared the way Linux does; the
+// port must then be free.
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// bindToDevice is a no-op where SO_BINDTODEVICE is not available; the
+// interface address selected still determines the server identifier.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
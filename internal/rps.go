@@ -0,0 +1,339 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the REST API resources read by RPSClient.  Domains are kept in
+// RPS, the devices it activated are registered in MPS.
+const (
+	rpsDomainsPath = "/api/v1/admin/domains"
+	mpsDevicesPath = "/api/v1/devices"
+)
+
+// maxRPSResponse bounds the size of a response read from RPS or MPS
+const maxRPSResponse = 16 << 20
+
+// rpsPageSize is the number of items asked for at a time, RPS and MPS
+// return 25 when not told
+const rpsPageSize = 100
+
+// RPSDomain is an AMT domain defined in RPS: the DNS suffix devices are
+// provisioned under and the metadata of its provisioning certificate.
+// Recent RPS releases keep the certificate itself in a secret store and do
+// not return it.
+type RPSDomain struct {
+	ProfileName       string `json:"profileName"`
+	DomainSuffix      string `json:"domainSuffix"`
+	ProvisioningCert  string `json:"provisioningCert,omitempty"` // base64, in CertStorageFormat
+	CertStorageFormat string `json:"provisioningCertStorageFormat,omitempty"`
+	ExpirationDate    string `json:"expirationDate,omitempty"`
+	TenantID          string `json:"tenantId,omitempty"`
+}
+
+// MPSDevice is a device registered in MPS, known by its AMT UUID.  The DNS
+// suffix is the one it was activated under, that of one of the RPS domains.
+// MPS does not record MAC addresses.
+type MPSDevice struct {
+	GUID      string `json:"guid"`
+	Hostname  string `json:"hostname,omitempty"`
+	DNSSuffix string `json:"dnsSuffix,omitempty"`
+	TenantID  string `json:"tenantId,omitempty"`
+}
+
+// RPSStatus reports the state of the RPS cache
+type RPSStatus struct {
+	URL       string      `json:"url"`
+	MPSURL    string      `json:"mpsUrl"`
+	Synced    time.Time   `json:"synced"`
+	LastError string      `json:"lastError,omitempty"`
+	Domains   []RPSDomain `json:"domains"`
+	Devices   int         `json:"devices"`
+}
+
+// RPSClient periodically fetches the domains defined in RPS and the devices
+// registered in MPS and caches them, so DHCP clients can be given the
+// suffix they were activated under.  Devices are matched to the domain of
+// their tenant with their DNS suffix.  The cached data is kept when a fetch
+// fails.  It is safe for concurrent use.
+type RPSClient struct {
+	// CertPassword decrypts the PKCS#12 provisioning certificates of the
+	// domains, RPS does not return their passwords
+	CertPassword string
+	// CheckDomain, when set, vets the domains fetched; those it returns an
+	// error for are left out of the cache
+	CheckDomain func(domain RPSDomain) error
+
+	baseURL  string
+	mpsURL   string
+	token    string
+	client   *http.Client
+	pageSize int
+
+	mu        sync.RWMutex
+	domains   map[string]RPSDomain // by domainKey
+	byUUID    map[string]string    // domainKey by lower case UUID
+	devices   int
+	synced    time.Time
+	lastError string
+}
+
+// NewRPSClient returns a client of the RPS at baseURL and the MPS at mpsURL,
+// authenticating with the bearer token when it is not empty.  Nothing is
+// fetched until Sync.
+func NewRPSClient(baseURL string, mpsURL string, token string) (*RPSClient, error) {
+	for _, raw := range []string{baseURL, mpsURL} {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid RPS or MPS url %q", raw)
+		}
+	}
+	return &RPSClient{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		mpsURL:   strings.TrimSuffix(mpsURL, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		pageSize: rpsPageSize,
+	}, nil
+}
+
+// domainKey identifies the domain of a tenant with a suffix
+func domainKey(tenantID string, suffix string) string {
+	return tenantID + "/" + strings.ToLower(suffix)
+}
+
+// Sync fetches the domains and devices and replaces the cache with them.
+func (c *RPSClient) Sync(ctx context.Context) error {
+	var domains []RPSDomain
+	var devices []MPSDevice
+	err := c.list(ctx, c.baseURL+rpsDomainsPath, &domains)
+	if err == nil {
+		err = c.list(ctx, c.mpsURL+mpsDevicesPath, &devices)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.lastError = err.Error()
+		c.mu.Unlock()
+		return err
+	}
+
+	byKey := make(map[string]RPSDomain)
+	for _, domain := range domains {
+		if domain.ProfileName == "" || domain.DomainSuffix == "" {
+			log.Println("Skipping RPS domain without a name or suffix: ", domain.ProfileName)
+			continue
+		}
+		suffix, err := NormalizeDomain(domain.DomainSuffix)
+		if err != nil {
+			log.Println("Skipping RPS domain ", domain.ProfileName, ": ", err)
+			continue
+		}
+		domain.DomainSuffix = suffix
+		if c.CheckDomain != nil {
+			if err := c.CheckDomain(domain); err != nil {
+				log.Println("Skipping RPS domain ", domain.ProfileName, ": ", err)
+				continue
+			}
+		}
+		key := domainKey(domain.TenantID, suffix)
+		if other, ok := byKey[key]; ok {
+			log.Println("Skipping RPS domain ", domain.ProfileName, ": suffix ", suffix, " already used by ", other.ProfileName)
+			continue
+		}
+		byKey[key] = domain
+	}
+	byUUID := make(map[string]string)
+	for _, device := range devices {
+		if device.GUID == "" || device.DNSSuffix == "" {
+			continue
+		}
+		suffix, err := NormalizeDomain(device.DNSSuffix)
+		if err != nil {
+			continue
+		}
+		byUUID[strings.ToLower(device.GUID)] = domainKey(device.TenantID, suffix)
+	}
+
+	c.mu.Lock()
+	c.domains = byKey
+	c.byUUID = byUUID
+	c.devices = len(devices)
+	c.synced = time.Now()
+	c.lastError = ""
+	c.mu.Unlock()
+	return nil
+}
+
+// list decodes every item of the collection at endpoint into items, a
+// pointer to a slice.  Items are fetched a page at a time, with the total
+// RPS and MPS report when asked for a count.  A plain JSON list is taken
+// as the whole collection.
+func (c *RPSClient) list(ctx context.Context, endpoint string, items interface{}) error {
+	var all []json.RawMessage
+	for {
+		page, total, err := c.get(ctx, fmt.Sprintf("%s?$top=%d&$skip=%d&$count=true", endpoint, c.pageSize, len(all)))
+		if err != nil {
+			return fmt.Errorf("GET %s: %w", endpoint, err)
+		}
+		all = append(all, page...)
+		if len(page) == 0 || len(all) >= total {
+			break
+		}
+	}
+	data, err := json.Marshal(all)
+	if err == nil {
+		err = json.Unmarshal(data, items)
+	}
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// get returns the items of the page at rawURL and the size of the whole
+// collection
+func (c *RPSClient) get(ctx context.Context, rawURL string) ([]json.RawMessage, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRPSResponse))
+	if err != nil {
+		return nil, 0, err
+	}
+	var page struct {
+		Data       []json.RawMessage `json:"data"`
+		TotalCount int               `json:"totalCount"`
+	}
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &page.Data); err != nil {
+			return nil, 0, err
+		}
+		return page.Data, len(page.Data), nil
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, 0, err
+	}
+	if page.Data == nil {
+		return nil, 0, errors.New("no data in response")
+	}
+	return page.Data, page.TotalCount, nil
+}
+
+// Watch syncs every interval until ctx is cancelled
+func (c *RPSClient) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Sync(ctx); err != nil && ctx.Err() == nil {
+				log.Println("Error syncing with RPS: ", err)
+			}
+		}
+	}
+}
+
+// Lookup returns the domain of the device registered in MPS with the AMT
+// UUID a client sent in option 97.
+func (c *RPSClient) Lookup(uuid string) (RPSDomain, bool) {
+	if c == nil || uuid == "" {
+		return RPSDomain{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok := c.byUUID[strings.ToLower(uuid)]
+	if !ok {
+		return RPSDomain{}, false
+	}
+	domain, ok := c.domains[key]
+	return domain, ok
+}
+
+// Domains returns the domains cached, ordered by profile name
+func (c *RPSClient) Domains() []RPSDomain {
+	domains := []RPSDomain{}
+	if c == nil {
+		return domains
+	}
+	c.mu.RLock()
+	for _, domain := range c.domains {
+		domains = append(domains, domain)
+	}
+	c.mu.RUnlock()
+	sort.Slice(domains, func(i, j int) bool { return domains[i].ProfileName < domains[j].ProfileName })
+	return domains
+}
+
+// Status returns the state of the cache, without certificates
+func (c *RPSClient) Status() RPSStatus {
+	domains := c.Domains()
+	for i := range domains {
+		domains[i].ProvisioningCert = ""
+	}
+	if c == nil {
+		return RPSStatus{Domains: domains}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return RPSStatus{
+		URL:       c.baseURL,
+		MPSURL:    c.mpsURL,
+		Synced:    c.synced,
+		LastError: c.lastError,
+		Domains:   domains,
+		Devices:   c.devices,
+	}
+}
+
+// Certificate decodes the provisioning certificate of the domain, stored
+// in RPS as base64 PKCS#12
+func (d RPSDomain) Certificate(password string) (*ProvisioningCert, error) {
+	if d.ProvisioningCert == "" {
+		return nil, errors.New("no provisioning certificate")
+	}
+	data, err := base64.StdEncoding.DecodeString(d.ProvisioningCert)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProvisioningCert(data, password)
+}
+
+// options returns the reply options set by the domain
+func (d RPSDomain) options() []Option {
+	opt, err := OptionDomain(OptionDomainName, d.DomainSuffix)
+	if err != nil {
+		return nil
+	}
+	return []Option{opt}
+}
@@ -0,0 +1,217 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRPSDomains = `[
+		{"profileName": "site1", "domainSuffix": "site1.example.com", "provisioningCert": "MIIK", "provisioningCertStorageFormat": "raw", "expirationDate": "2030-01-01T00:00:00.000Z", "tenantId": "", "version": "1"},
+		{"profileName": "lab", "domainSuffix": "lab.example.com", "provisioningCertStorageFormat": "raw", "expirationDate": "2030-01-01T00:00:00.000Z", "tenantId": "", "version": "1"},
+		{"profileName": "broken", "domainSuffix": "", "tenantId": ""}
+	]`
+	testMPSDevices = `[
+		{"guid": "8e2a5d1c-0b3f-4c6a-9d7e-112233445566", "hostname": "lab-nuc", "tags": [], "mpsInstance": "mps-0", "connectionStatus": true, "mpsusername": "admin", "tenantId": "", "friendlyName": "", "dnsSuffix": "lab.example.com"},
+		{"guid": "4C4C4544-0048-4A10-8056-B4C04F4D4D32", "hostname": "desk-12", "tags": ["site1"], "mpsInstance": "mps-0", "connectionStatus": false, "mpsusername": "admin", "tenantId": "", "friendlyName": "", "dnsSuffix": "Site1.Example.com"},
+		{"guid": "123e4567-e89b-12d3-a456-426614174000", "hostname": "guest", "tags": [], "tenantId": "", "dnsSuffix": "example.net"},
+		{"guid": "9f1b2c3d-4e5f-4a6b-8c7d-0e1f2a3b4c5d", "hostname": "other", "tags": [], "tenantId": "tenant2", "dnsSuffix": "lab.example.com"}
+	]`
+)
+
+// newTestRPS starts a stand-in for RPS and MPS behind a gateway, under
+// /rps and /mps, serving domains and devices to requests carrying the
+// bearer token "token".  Lists are paged with $top and $skip, 25 items by
+// default, and wrapped with their total count when $count is true.  Bodies
+// that are not a JSON list are served as they are.
+func newTestRPS(t *testing.T, domains string, devices string) *httptest.Server {
+	mux := http.NewServeMux()
+	for path, body := range map[string]string{"/rps" + rpsDomainsPath: domains, "/mps" + mpsDevicesPath: devices} {
+		body := body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			var items []json.RawMessage
+			if err := json.Unmarshal([]byte(body), &items); err != nil {
+				w.Write([]byte(body))
+				return
+			}
+			query := r.URL.Query()
+			top, skip := 25, 0
+			if v := query.Get("$top"); v != "" {
+				top, _ = strconv.Atoi(v)
+			}
+			if v := query.Get("$skip"); v != "" {
+				skip, _ = strconv.Atoi(v)
+			}
+			page := []json.RawMessage{}
+			for i := skip; i < len(items) && i < skip+top; i++ {
+				page = append(page, items[i])
+			}
+			if query.Get("$count") == "true" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": page, "totalCount": len(items)})
+				return
+			}
+			json.NewEncoder(w).Encode(page)
+		})
+	}
+	rps := httptest.NewServer(mux)
+	t.Cleanup(rps.Close)
+	return rps
+}
+
+// newTestRPSClientOf returns a client of the stand-in rps, not synced
+func newTestRPSClientOf(t *testing.T, rps *httptest.Server) *RPSClient {
+	c, err := NewRPSClient(rps.URL+"/rps/", rps.URL+"/mps", "token")
+	assert.NoError(t, err)
+	return c
+}
+
+func newTestRPSClient(t *testing.T) *RPSClient {
+	c := newTestRPSClientOf(t, newTestRPS(t, testRPSDomains, testMPSDevices))
+	assert.NoError(t, c.Sync(context.Background()))
+	return c
+}
+
+func TestNewRPSClientErrors(t *testing.T) {
+	for _, u := range []string{"", "rps.example.com", "ftp://rps.example.com", "https://"} {
+		_, err := NewRPSClient(u, "https://mps.example.com", "")
+		assert.Error(t, err, u)
+		_, err = NewRPSClient("https://rps.example.com", u, "")
+		assert.Error(t, err, u)
+	}
+}
+
+func TestRPSClientLookup(t *testing.T) {
+	c := newTestRPSClient(t)
+
+	domain, ok := c.Lookup("4c4c4544-0048-4a10-8056-b4c04f4d4d32")
+	assert.True(t, ok)
+	assert.Equal(t, "site1", domain.ProfileName)
+	assert.Equal(t, "site1.example.com", domain.DomainSuffix)
+	assert.Equal(t, "raw", domain.CertStorageFormat)
+
+	domain, ok = c.Lookup("8E2A5D1C-0B3F-4C6A-9D7E-112233445566")
+	assert.True(t, ok)
+	assert.Equal(t, "lab.example.com", domain.DomainSuffix)
+
+	// no domain has the suffix, or not in the tenant of the device
+	for _, uuid := range []string{"123e4567-e89b-12d3-a456-426614174000", "9f1b2c3d-4e5f-4a6b-8c7d-0e1f2a3b4c5d", "00000000-0000-0000-0000-000000000000", ""} {
+		_, ok = c.Lookup(uuid)
+		assert.False(t, ok, uuid)
+	}
+	_, ok = (*RPSClient)(nil).Lookup("8e2a5d1c-0b3f-4c6a-9d7e-112233445566")
+	assert.False(t, ok)
+}
+
+func TestRPSClientStatus(t *testing.T) {
+	c := newTestRPSClient(t)
+
+	status := c.Status()
+
+	assert.Empty(t, status.LastError)
+	assert.False(t, status.Synced.IsZero())
+	assert.True(t, strings.HasSuffix(status.MPSURL, "/mps"))
+	assert.Equal(t, 4, status.Devices)
+	assert.Len(t, status.Domains, 2)
+	assert.Equal(t, "lab", status.Domains[0].ProfileName)
+	assert.Empty(t, status.Domains[1].ProvisioningCert)
+	assert.Equal(t, "MIIK", c.Domains()[1].ProvisioningCert)
+	assert.Empty(t, (*RPSClient)(nil).Status().Domains)
+}
+
+func TestRPSClientSyncPages(t *testing.T) {
+	c := newTestRPSClientOf(t, newTestRPS(t, testRPSDomains, testMPSDevices))
+	c.pageSize = 1
+
+	assert.NoError(t, c.Sync(context.Background()))
+
+	assert.Len(t, c.Domains(), 2)
+	assert.Equal(t, 4, c.Status().Devices)
+	_, ok := c.Lookup("9f1b2c3d-4e5f-4a6b-8c7d-0e1f2a3b4c5d")
+	assert.False(t, ok)
+	_, ok = c.Lookup("4c4c4544-0048-4a10-8056-b4c04f4d4d32")
+	assert.True(t, ok)
+}
+
+func TestRPSClientSyncErrorKeepsCache(t *testing.T) {
+	c := newTestRPSClient(t)
+	c.token = "expired"
+
+	err := c.Sync(context.Background())
+
+	assert.Error(t, err)
+	assert.Contains(t, c.Status().LastError, "401")
+	assert.Len(t, c.Domains(), 2)
+}
+
+func TestRPSClientSyncInvalid(t *testing.T) {
+	for _, body := range []string{`{"totalCount": 0}`, `[{"profileName": 1}]`, `not json`} {
+		c := newTestRPSClientOf(t, newTestRPS(t, body, `[]`))
+		assert.Error(t, c.Sync(context.Background()), body)
+	}
+	c := newTestRPSClientOf(t, newTestRPS(t, testRPSDomains, `{"data": [{"guid": 1}], "totalCount": 1}`))
+	assert.Error(t, c.Sync(context.Background()))
+}
+
+func TestRPSClientSyncInvalidSuffix(t *testing.T) {
+	rps := newTestRPS(t, `[
+		{"profileName": "site1", "domainSuffix": "site 1.example.com"},
+		{"profileName": "lab", "domainSuffix": "Lab.Bücher.example."}
+	]`, `[]`)
+	c := newTestRPSClientOf(t, rps)
+
+	assert.NoError(t, c.Sync(context.Background()))
+
+	domains := c.Domains()
+	assert.Len(t, domains, 1)
+	assert.Equal(t, "lab.xn--bcher-kva.example", domains[0].DomainSuffix)
+}
+
+func TestHandleRequestRPS(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.RPS = newTestRPSClient(t)
+	s.cfg.Classes = DefaultClientClasses()
+
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionClientMachineID, newTestMachineID())
+
+	offer, err := s.handleRequest(req, net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	options, _ := offer.Options()
+	assert.Equal(t, []byte("lab.example.com"), options[OptionDomainName])
+}
+
+func TestHandleProxyRPS(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.Proxy = true
+	cfg.RPS = newTestRPSClient(t)
+	s, _ := NewServer(cfg)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	// the ACK carries no option 97, the UUID is snooped from the request
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionClientMachineID, newTestMachineID())
+	s.snoopRequest(req, serverIP)
+
+	reply, err := s.handleProxy(newTestSiteAck(), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte("lab.example.com"), options[OptionDomainName])
+}
@@ -0,0 +1,194 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Scope is the configuration served on one subnet.  Requests relayed from
+// a subnet are answered from the scope that contains the relay address,
+// others from the scope holding the client address or the address of the
+// interface the request came in on.
+type Scope struct {
+	Name         string
+	Subnet       *net.IPNet
+	Pool         *Pool
+	Router       net.IP        // option 3, omitted if nil
+	DNSServers   []net.IP      // option 6, the server list if empty
+	DomainName   string        // option 15, the server suffix if empty
+	NTPServers   []net.IP      // option 42, the server list if empty
+	LeaseTime    time.Duration // option 51, the server lease time if zero
+	DomainSearch []string      // option 119, the server list if empty
+}
+
+type scopeConfig struct {
+	Name         string   `yaml:"name"`
+	CIDR         string   `yaml:"cidr"`
+	Range        string   `yaml:"range"`
+	Exclude      string   `yaml:"exclude"`
+	Router       string   `yaml:"router"`
+	DNSServers   []string `yaml:"dnsServers"`
+	DomainName   string   `yaml:"domainName"`
+	NTPServers   []string `yaml:"ntpServers"`
+	LeaseTime    string   `yaml:"leaseTime"`
+	DomainSearch []string `yaml:"domainSearch"`
+}
+
+func (cfg scopeConfig) scope() (*Scope, error) {
+	_, subnet, err := net.ParseCIDR(cfg.CIDR)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 cidr %q", cfg.CIDR)
+	}
+	if cfg.Range == "" {
+		return nil, errors.New("range is required")
+	}
+	start, end, err := parseRange(cfg.Range)
+	if err != nil {
+		return nil, err
+	}
+	if !subnet.Contains(start) || !subnet.Contains(end) {
+		return nil, fmt.Errorf("range %s is outside %s", cfg.Range, subnet)
+	}
+	pool, err := ParsePool(cfg.Range, cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	sc := &Scope{Name: cfg.Name, Subnet: subnet, Pool: pool, DomainName: cfg.DomainName}
+	if sc.Name == "" {
+		sc.Name = subnet.String()
+	}
+	if cfg.Router != "" {
+		sc.Router = net.ParseIP(cfg.Router).To4()
+		if sc.Router == nil {
+			return nil, fmt.Errorf("invalid router %q", cfg.Router)
+		}
+		if !subnet.Contains(sc.Router) {
+			return nil, fmt.Errorf("router %s is outside %s", sc.Router, subnet)
+		}
+	}
+	if sc.DNSServers, err = parseScopeServers(OptionDomainNameServer, cfg.DNSServers); err != nil {
+		return nil, err
+	}
+	if sc.NTPServers, err = parseScopeServers(OptionNTPServers, cfg.NTPServers); err != nil {
+		return nil, err
+	}
+	if cfg.DomainName != "" {
+		if sc.DomainName, err = NormalizeDomain(cfg.DomainName); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range cfg.DomainSearch {
+		normalized, err := NormalizeDomain(name)
+		if err != nil {
+			return nil, err
+		}
+		sc.DomainSearch = append(sc.DomainSearch, normalized)
+	}
+	if cfg.LeaseTime != "" {
+		sc.LeaseTime, err = time.ParseDuration(cfg.LeaseTime)
+		if err != nil {
+			return nil, err
+		}
+		if sc.LeaseTime < time.Second {
+			return nil, fmt.Errorf("lease time %s is too short", sc.LeaseTime)
+		}
+	}
+	return sc, nil
+}
+
+// parseScopeServers parses the server addresses listed for option code
+func parseScopeServers(code OptionCode, servers []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, server := range servers {
+		ip := net.ParseIP(server).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid server address %q", server)
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) > 0 {
+		if _, err := OptionIPList(code, ips...); err != nil {
+			return nil, err
+		}
+	}
+	return ips, nil
+}
+
+// options returns the reply options overridden by the scope.  The values
+// were checked when the scope was loaded.
+func (sc *Scope) options() []Option {
+	opts := []Option{{Code: OptionSubnetMask, Value: []byte(net.IP(sc.Subnet.Mask).To4())}}
+	if sc.Router != nil {
+		opt, _ := OptionIPList(OptionRouter, sc.Router)
+		opts = append(opts, opt)
+	}
+	if len(sc.DNSServers) > 0 {
+		opt, _ := OptionIPList(OptionDomainNameServer, sc.DNSServers...)
+		opts = append(opts, opt)
+	}
+	if sc.DomainName != "" {
+		opt, _ := OptionDomain(OptionDomainName, sc.DomainName)
+		opts = append(opts, opt)
+	}
+	if len(sc.DomainSearch) > 0 {
+		opt, _ := OptionDomainList(OptionDomainSearch, sc.DomainSearch...)
+		opts = append(opts, opt)
+	}
+	if len(sc.NTPServers) > 0 {
+		opt, _ := OptionIPList(OptionNTPServers, sc.NTPServers...)
+		opts = append(opts, opt)
+	}
+	if sc.LeaseTime > 0 {
+		opts = append(opts,
+			OptionUint32(OptionIPLeaseTime, uint32(sc.LeaseTime/time.Second)),
+			OptionUint32(OptionRenewalTime, uint32(sc.LeaseTime/2/time.Second)),
+			OptionUint32(OptionRebindingTime, uint32(sc.LeaseTime*7/8/time.Second)),
+		)
+	}
+	return opts
+}
+
+// findScope returns the scope whose subnet contains ip, or nil
+func findScope(scopes []*Scope, ip net.IP) *Scope {
+	for _, scope := range scopes {
+		if scope.Subnet.Contains(ip) {
+			return scope
+		}
+	}
+	return nil
+}
+
+// selectScope returns the scope a request is answered from.  Relayed
+// requests are matched on the relay link, others on the address the client
+// already holds or, failing that, the address of the interface the request
+// arrived on.  It returns nil if no scope matches.
+func selectScope(scopes []*Scope, req Packet, link net.IP, serverIP net.IP) *Scope {
+	if link != nil {
+		return findScope(scopes, link)
+	}
+	if ciaddr := req.CIAddr(); !ciaddr.Equal(net.IPv4zero) {
+		if scope := findScope(scopes, ciaddr); scope != nil {
+			return scope
+		}
+	}
+	return findScope(scopes, serverIP)
+}
+
+// relayLink returns the address identifying the subnet of a relayed
+// request: the link selection sub-option of option 82 when present,
+// otherwise giaddr.  It returns nil for requests that were not relayed.
+func relayLink(req Packet, info RelayAgentInfo) net.IP {
+	if info.LinkSelection != nil {
+		return info.LinkSelection
+	}
+	if giaddr := req.GIAddr(); !giaddr.Equal(net.IPv4zero) {
+		return giaddr
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testScopes = `
+scopes:
+  - name: branch1
+    cidr: 10.50.0.0/24
+    range: 10.50.0.100-10.50.0.101
+    exclude: 10.50.0.100
+    router: 10.50.0.1
+    dnsServers: [10.50.0.53, 10.0.0.53]
+    domainName: branch1.example.com
+    domainSearch: [branch1.example.com, example.com]
+    ntpServers: [10.0.0.123]
+    leaseTime: 8h
+  - cidr: 10.60.0.0/16
+    range: 10.60.1.10-10.60.1.20
+`
+
+func TestScopeOptions(t *testing.T) {
+	cfg, _ := parseConfigFile([]byte(testScopes))
+	scopes := cfg.Scopes
+
+	assert.Equal(t, []Option{
+		{Code: OptionSubnetMask, Value: []byte{255, 255, 255, 0}},
+		{Code: OptionRouter, Value: []byte{10, 50, 0, 1}},
+		{Code: OptionDomainNameServer, Value: []byte{10, 50, 0, 53, 10, 0, 0, 53}},
+		{Code: OptionDomainName, Value: []byte("branch1.example.com")},
+		{Code: OptionDomainSearch, Value: []byte("\x07branch1\x07example\x03com\x00\xc0\x08")},
+		{Code: OptionNTPServers, Value: []byte{10, 0, 0, 123}},
+		{Code: OptionIPLeaseTime, Value: []byte{0, 0, 0x70, 0x80}},
+		{Code: OptionRenewalTime, Value: []byte{0, 0, 0x38, 0x40}},
+		{Code: OptionRebindingTime, Value: []byte{0, 0, 0x62, 0x70}},
+	}, scopes[0].options())
+	assert.Equal(t, []Option{{Code: OptionSubnetMask, Value: []byte{255, 255, 0, 0}}}, scopes[1].options())
+}
+
+func TestFindScope(t *testing.T) {
+	cfg, _ := parseConfigFile([]byte(testScopes))
+	scopes := cfg.Scopes
+
+	assert.Equal(t, "branch1", findScope(scopes, net.IPv4(10, 50, 0, 1)).Name)
+	assert.Equal(t, "10.60.0.0/16", findScope(scopes, net.IPv4(10, 60, 9, 1)).Name)
+	assert.Nil(t, findScope(scopes, net.IPv4(10, 70, 0, 1)))
+}
+
+func TestSelectScope(t *testing.T) {
+	cfg, _ := parseConfigFile([]byte(testScopes))
+	scopes := cfg.Scopes
+	req := newTestRequest(dhcpDiscover)
+
+	assert.Equal(t, "branch1", selectScope(scopes, req, nil, net.IPv4(10, 50, 0, 2)).Name)
+	assert.Nil(t, selectScope(scopes, req, nil, net.IPv4(10, 20, 30, 34)))
+	assert.Equal(t, "10.60.0.0/16", selectScope(scopes, req, net.IPv4(10, 60, 0, 1), net.IPv4(10, 50, 0, 2)).Name)
+	assert.Nil(t, selectScope(scopes, req, net.IPv4(10, 70, 0, 1), net.IPv4(10, 50, 0, 2)))
+
+	// a renewing client is known by the address it holds
+	req.SetCIAddr(net.ParseIP("10.60.1.10"))
+	assert.Equal(t, "10.60.0.0/16", selectScope(scopes, req, nil, net.IPv4(10, 50, 0, 2)).Name)
+	req.SetCIAddr(net.ParseIP("10.70.0.9"))
+	assert.Equal(t, "branch1", selectScope(scopes, req, nil, net.IPv4(10, 50, 0, 2)).Name)
+}
+
+func TestRelayLink(t *testing.T) {
+	req := newTestRequest(dhcpDiscover)
+	assert.Nil(t, relayLink(req, RelayAgentInfo{}))
+
+	req.SetGIAddr(net.ParseIP("10.50.0.1"))
+	assert.Equal(t, "10.50.0.1", relayLink(req, RelayAgentInfo{}).String())
+	assert.Equal(t, "10.60.0.0", relayLink(req, RelayAgentInfo{LinkSelection: net.IPv4(10, 60, 0, 0)}).String())
+}
@@ -0,0 +1,773 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds everything a Server needs.  Start from NewConfig to get the
+// defaults and override fields as required.
+type Config struct {
+	DNSSuffix    string           // domain name sent in option 15
+	AssignIP     net.IP           // address handed to clients without a reservation
+	SubnetMask   net.IP           // option 1, omitted if nil
+	Router       net.IP           // option 3, omitted if nil
+	DNSServers   []net.IP         // option 6, omitted if empty
+	NTPServers   []net.IP         // option 42, omitted if empty
+	DomainSearch []string         // option 119, omitted if empty
+	Reservations *Reservations    // optional per-device overrides
+	RPS          *RPSClient       // optional, suffixes of the devices activated by RPS
+	Leases       LeaseStore       // leases issued, in memory if nil
+	Pool         *Pool            // optional, AssignIP is handed out if nil
+	Scopes       []*Scope         // subnets served, selected by relay, client or interface address
+	RelayRules   []RelayRule      // policy for relayed requests by option 82
+	Classes      []ClientClass    // if set, only members and reserved clients are answered
+	LeaseTime    time.Duration    // option 51
+	RenewalTime  time.Duration    // option 58, T1
+	RebindTime   time.Duration    // option 59, T2
+	ListenAddr   string           // UDP address requests are read from
+	AckMAC       net.HardwareAddr // client targeted by unsolicited ACKs
+	Interface    string           // comma separated interface names, indexes or CIDRs; empty to auto-detect
+	BindToDevice bool             // restrict sockets to the selected interface
+	// ServeUnclassified also answers the clients of no class when Classes
+	// are set, without the suffix, rather than leaving them to another
+	// server
+	ServeUnclassified bool
+	// LimitedBroadcast sends broadcasts to 255.255.255.255 rather than the
+	// directed broadcast address of the interface subnet
+	LimitedBroadcast bool
+	// RawSocket sends and receives through an AF_PACKET socket so replies
+	// can be unicast to clients that have no address yet, see ListenRaw
+	RawSocket bool
+	// Listen opens the transports of the server, UDP or raw sockets as
+	// chosen above when nil
+	Listen ListenFunc
+	// Proxy leaves addressing to the site DHCP server and only injects the
+	// DNS suffix into the leases it grants, see handleProxy
+	Proxy           bool
+	ProxyListenAddr string // UDP address server replies are read from in proxy mode
+	// ProvisioningCert, when set, must cover every DNS suffix served, as
+	// AMT refuses admin control mode activation otherwise.  Suffixes it
+	// does not cover are logged, or refused with StrictSuffix.  NewServer
+	// hooks the check into reloads of Reservations and RPS.
+	ProvisioningCert *ProvisioningCert
+	StrictSuffix     bool
+	Enumerator       NetworkEnumerator
+}
+
+// Status reports the activity of a Server since it was created
+type Status struct {
+	Started   time.Time `json:"started"`
+	Listening bool      `json:"listening"`
+	Interface string    `json:"interface,omitempty"`
+	Broadcast string    `json:"broadcast,omitempty"`
+	Requests  uint64    `json:"requests"`
+	Offers    uint64    `json:"offers"`
+	Acks      uint64    `json:"acks"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Server answers DHCP requests with replies carrying the configured DNS
+// suffix.  Each Server owns its configuration, leases and socket, so several
+// can run in one process.
+type Server struct {
+	cfg     Config
+	options []Option
+	snooper *Snooper
+	status  serverStatus
+}
+
+type serverStatus struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// NewConfig returns the default configuration for serving dnsSuffix
+func NewConfig(dnsSuffix string) Config {
+	ackMAC, _ := net.ParseMAC("54-B2-03-89-D3-B9")
+	return Config{
+		DNSSuffix:       dnsSuffix,
+		AssignIP:        net.IPv4(169, 254, 214, 131).To4(),
+		SubnetMask:      net.IPv4(255, 255, 255, 0).To4(),
+		LeaseTime:       24 * time.Hour,
+		RenewalTime:     12 * time.Hour,
+		RebindTime:      21 * time.Hour,
+		ListenAddr:      ":" + serverPort,
+		AckMAC:          ackMAC,
+		ProxyListenAddr: ":" + destPort,
+		BindToDevice:    true,
+		Enumerator:      NetPkgEnumerator(),
+	}
+}
+
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.DNSSuffix == "" {
+		return nil, errors.New("dns suffix cannot be empty")
+	}
+	suffix, err := NormalizeDomain(cfg.DNSSuffix)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DNSSuffix = suffix
+	if cfg.AssignIP.To4() == nil {
+		return nil, errors.New("assigned address must be IPv4")
+	}
+	if cfg.Enumerator.Interfaces == nil || cfg.Enumerator.Addrs == nil {
+		cfg.Enumerator = NetPkgEnumerator()
+	}
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":" + serverPort
+	}
+	if cfg.ProxyListenAddr == "" {
+		cfg.ProxyListenAddr = ":" + destPort
+	}
+	if cfg.Proxy && cfg.Pool != nil {
+		return nil, errors.New("address pool cannot be used in proxy mode")
+	}
+	if cfg.Leases == nil {
+		cfg.Leases = NewMemoryLeaseStore()
+	}
+	if cfg.LeaseTime <= 0 {
+		cfg.LeaseTime = 24 * time.Hour
+	}
+	if cfg.RenewalTime <= 0 || cfg.RenewalTime > cfg.LeaseTime {
+		cfg.RenewalTime = cfg.LeaseTime / 2
+	}
+	if cfg.RebindTime <= 0 || cfg.RebindTime > cfg.LeaseTime {
+		cfg.RebindTime = cfg.LeaseTime * 7 / 8
+	}
+
+	options, err := setDHCPOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:     cfg,
+		options: options,
+	}
+	if err := s.checkSuffixes(); err != nil {
+		return nil, err
+	}
+	if cfg.Reservations != nil && cfg.ProvisioningCert != nil {
+		cfg.Reservations.CheckDomain = s.checkSuffix
+	}
+	if cfg.RPS != nil {
+		cfg.RPS.CheckDomain = s.checkRPSDomain
+	}
+	if cfg.Proxy {
+		// injecting into every lease on the segment would reconfigure
+		// ordinary hosts, so proxy mode always classifies
+		classes := cfg.Classes
+		if len(classes) == 0 {
+			classes = DefaultClientClasses()
+		}
+		s.snooper = NewSnooper(classes)
+	}
+	s.status.status.Started = time.Now()
+	return s, nil
+}
+
+// checkSuffix returns an error for a suffix the provisioning certificate
+// does not cover in strict mode, and only logs it otherwise.
+func (s *Server) checkSuffix(suffix string) error {
+	if s.cfg.ProvisioningCert == nil {
+		return nil
+	}
+	return s.suffixMismatch(s.cfg.ProvisioningCert.Check(suffix))
+}
+
+// checkRPSDomain checks the suffix of an RPS domain against the certificate
+// stored with it, or the configured one when it has none.  A stored
+// certificate that cannot be decoded covers nothing.
+func (s *Server) checkRPSDomain(domain RPSDomain) error {
+	cert := s.cfg.ProvisioningCert
+	if domain.ProvisioningCert != "" {
+		var err error
+		cert, err = domain.Certificate(s.cfg.RPS.CertPassword)
+		if err != nil {
+			return s.suffixMismatch(fmt.Errorf("%w: certificate of RPS domain %s cannot be decoded: %v", ErrSuffixMismatch, domain.ProfileName, err))
+		}
+	}
+	if cert == nil {
+		return nil
+	}
+	return s.suffixMismatch(cert.Check(domain.DomainSuffix))
+}
+
+func (s *Server) suffixMismatch(err error) error {
+	if err != nil && !s.cfg.StrictSuffix {
+		log.Println("Warning: ", err)
+		return nil
+	}
+	return err
+}
+
+// checkSuffixes checks every suffix configured, from flags, the
+// configuration file and reservations.  RPS domains are checked as they
+// are synced.
+func (s *Server) checkSuffixes() error {
+	cert := s.cfg.ProvisioningCert
+	if cert != nil && cert.Expired(time.Now()) {
+		log.Println("Warning: provisioning certificate ", cert.Subject, " expired on ", cert.NotAfter)
+	}
+	suffixes := []string{s.cfg.DNSSuffix}
+	for _, scope := range s.cfg.Scopes {
+		suffixes = append(suffixes, scope.DomainName)
+	}
+	for _, rule := range s.cfg.RelayRules {
+		suffixes = append(suffixes, rule.DomainName)
+	}
+	for _, class := range s.cfg.Classes {
+		suffixes = append(suffixes, class.DomainName)
+	}
+	suffixes = append(suffixes, s.cfg.Reservations.DomainNames()...)
+	for _, suffix := range suffixes {
+		if suffix == "" {
+			continue
+		}
+		if err := s.checkSuffix(suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config returns the configuration the server was created with
+func (s *Server) Config() Config { return s.cfg }
+
+// Status returns a snapshot of the server activity
+func (s *Server) Status() Status {
+	s.status.mu.Lock()
+	defer s.status.mu.Unlock()
+	return s.status.status
+}
+
+// Leases returns the leases issued by the server
+func (s *Server) Leases() []Lease { return s.cfg.Leases.List() }
+
+// Snooped returns the clients followed in proxy mode
+func (s *Server) Snooped() []SnoopedClient { return s.snooper.Clients() }
+
+// Run binds the listen address on each interface served and answers
+// DISCOVER and REQUEST messages with OFFER and ACK replies.  In proxy mode
+// requests are only snooped, and the proxy listen address is bound as well
+// to follow the ACKs of other servers.  Replies, the injected ACKs too, are
+// sent from the listen address.  It returns nil once ctx is cancelled, or
+// the error that stopped a socket.
+func (s *Server) Run(ctx context.Context) error {
+	ifaces, err := selectInterfaces(s.cfg.Enumerator, s.cfg.Interface)
+	if err != nil {
+		return err
+	}
+	addrs := []string{s.cfg.ListenAddr}
+	handlers := []packetHandler{s.handleRequest}
+	if s.cfg.Proxy {
+		addrs = append(addrs, s.cfg.ProxyListenAddr)
+		handlers = []packetHandler{s.snoopRequest, s.handleProxy}
+	}
+
+	conns := make(map[Transport]servedConn)
+	defer func() {
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+	var names, broadcasts []string
+	for _, iface := range ifaces {
+		broadcast := s.broadcastAddr(iface)
+		if s.cfg.Proxy {
+			log.Println("Proxying DHCP on interface ", iface.Name, " address ", iface.Address.IP, " broadcast ", broadcast)
+		} else {
+			log.Println("Serving DHCP on interface ", iface.Name, " address ", iface.Address.IP, " broadcast ", broadcast)
+		}
+		var reply Transport
+		for i, addr := range addrs {
+			// the proxy listen address is the client port, which a DHCP
+			// client on this host may hold as well
+			conn, err := s.listen(i > 0)(ctx, iface, addr)
+			if err != nil {
+				log.Println("failed listen step ", err)
+				return err
+			}
+			if reply == nil {
+				reply = conn
+			}
+			conns[conn] = servedConn{handle: handlers[i], reply: reply, serverIP: iface.Address.IP, broadcast: broadcast}
+		}
+		names = append(names, iface.Name)
+		broadcasts = append(broadcasts, broadcast)
+	}
+
+	// Unblock ReadFrom when asked to stop, or when another socket failed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	s.status.setInterface(strings.Join(names, ","), strings.Join(broadcasts, ","))
+	s.status.setListening(true)
+	defer s.status.setListening(false)
+
+	errs := make(chan error, len(conns))
+	for conn, served := range conns {
+		go func(conn Transport, served servedConn) {
+			errs <- s.serve(ctx, conn, served)
+		}(conn, served)
+	}
+	var result error
+	for range conns {
+		if err := <-errs; err != nil && result == nil {
+			result = err
+			cancel()
+		}
+	}
+	return result
+}
+
+// servedConn is what a transport opened by Run is served with
+type servedConn struct {
+	handle    packetHandler
+	reply     Transport // where replies are written
+	serverIP  net.IP
+	broadcast string
+}
+
+// listen returns the ListenFunc transports are opened with.  Shared UDP
+// sockets set SO_REUSEADDR, so other programs can bind the port too.
+func (s *Server) listen(shared bool) ListenFunc {
+	switch {
+	case s.cfg.Listen != nil:
+		return s.cfg.Listen
+	case s.cfg.RawSocket:
+		return ListenRawTransport
+	default:
+		return listenUDP(s.cfg.BindToDevice, shared)
+	}
+}
+
+// packetHandler returns the reply to a packet received on a Server socket,
+// or nil when there is nothing to send.
+type packetHandler func(pkt Packet, serverIP net.IP) (Packet, error)
+
+// serve reads packets from conn and writes the replies the handler of
+// served returns to its reply transport until ctx is cancelled.
+func (s *Server) serve(ctx context.Context, conn Transport, served servedConn) error {
+	buffer := make([]byte, 1500)
+	for {
+		n, peer, error := conn.ReadFrom(buffer)
+		if error != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.status.setError(error)
+			return error
+		}
+		req, error := ParsePacket(buffer[:n])
+		if error != nil {
+			log.Println("Discarding malformed packet: ", error)
+			continue
+		}
+		s.status.countRequest()
+		reply, error := served.handle(req, served.serverIP)
+		if error != nil {
+			log.Println("Error handling request: ", error)
+			s.status.setError(error)
+			continue
+		}
+		if reply == nil {
+			continue
+		}
+		clientAddr, error := s.replyAddr(reply, served.broadcast)
+		if error != nil {
+			log.Println("Error resolving reply address: ", error)
+			continue
+		}
+		if _, error = served.reply.WriteTo(reply, Peer{Interface: peer.Interface, Addr: clientAddr}); error != nil {
+			log.Println("Error writing reply: ", error)
+			s.status.setError(error)
+			continue
+		}
+		s.status.countReply(reply)
+	}
+}
+
+// SendAck broadcasts an unsolicited DHCPACK carrying domainName in option 15
+// to the configured AckMAC.
+func (s *Server) SendAck(domainName string) error {
+	if error := s.checkSuffix(domainName); error != nil {
+		return error
+	}
+
+	ifaces, error := selectInterfaces(s.cfg.Enumerator, s.cfg.Interface)
+	if error != nil {
+		return error
+	}
+	// The ack goes out of the first interface served
+	iface := ifaces[0]
+	// DHCP packets are broadcasted.  Get broadcast address
+	broadcast := s.broadcastAddr(iface)
+	log.Println("Sending Ack on interface ", iface.Name, " to broadcast ", broadcast)
+
+	// Initialize info for ack packet
+	serverIP := iface.Address.IP
+	domain, error := OptionDomain(OptionDomainName, domainName)
+	if error != nil {
+		return error
+	}
+	options := mergeOptions(s.options, []Option{domain})
+
+	// Create ack packet
+	req, error := unsolicitedRequest(s.cfg.AckMAC)
+	if error != nil {
+		return error
+	}
+	packet, error := createReplyPacket(req, dhcpAck, serverIP, s.cfg.AssignIP, options)
+	if error != nil {
+		return error
+	}
+
+	// Write ack packet
+	addr := ":0"
+	if s.cfg.RawSocket && s.cfg.Listen == nil {
+		// raw sockets send from the port they are opened on
+		addr = ":" + serverPort
+	}
+	conn, error := s.listen(false)(context.Background(), iface, addr)
+	if error != nil {
+		log.Println("failed dial step ", error)
+		return error
+	}
+	defer conn.Close()
+	clientAddr, error := net.ResolveUDPAddr("udp4", net.JoinHostPort(broadcast, destPort))
+	if error != nil {
+		return error
+	}
+	_, error = conn.WriteTo(packet, Peer{Interface: iface.Name, Addr: clientAddr})
+	if error != nil {
+		log.Println(error)
+	} else {
+		s.status.countReply(packet)
+	}
+
+	return error
+}
+
+// replyAddr chooses where reply is sent.  Raw sockets can reach clients by
+// chaddr, so those not asking for a broadcast are unicast to at yiaddr as
+// RFC 2131 section 4.1 describes.
+func (s *Server) replyAddr(reply Packet, broadcast string) (*net.UDPAddr, error) {
+	relayed := !reply.GIAddr().Equal(net.IPv4zero)
+	if s.cfg.RawSocket && !relayed && !reply.Broadcast() && reply.CIAddr().Equal(net.IPv4zero) && !reply.YIAddr().Equal(net.IPv4zero) {
+		return net.ResolveUDPAddr("udp4", net.JoinHostPort(reply.YIAddr().String(), destPort))
+	}
+	return replyAddr(reply, broadcast)
+}
+
+// broadcastAddr returns the address broadcasts are sent to on iface
+func (s *Server) broadcastAddr(iface NetworkInterface) string {
+	if s.cfg.LimitedBroadcast {
+		return net.IPv4bcast.String()
+	}
+	return directedBroadcast(iface.Address).String()
+}
+
+// handleRequest drives the server side of the DHCP exchange for a single
+// request.  A nil packet is returned when the request needs no reply.
+func (s *Server) handleRequest(req Packet, serverIP net.IP) (Packet, error) {
+	if req.OpCode() != bootRequest {
+		return nil, nil
+	}
+	options, error := req.Options()
+	if error != nil {
+		return nil, error
+	}
+	msgType := options.MessageType()
+	if msgType == 0 {
+		return nil, errors.New("missing DHCP message type")
+	}
+
+	mac := req.CHAddr().String()
+	lease, hasLease := s.cfg.Leases.Get(mac)
+	if hasLease && lease.Expired(time.Now()) {
+		hasLease = false
+	}
+
+	// Requests are answered from the scope of the client subnet, relayed
+	// ones unless a relay rule picks another scope
+	pool := s.cfg.Pool
+	replyOptions := s.options
+	info, error := ParseRelayAgentInfo(options[OptionRelayAgentInformation])
+	if error != nil {
+		return nil, error
+	}
+	link := relayLink(req, info)
+	scope := selectScope(s.cfg.Scopes, req, link, serverIP)
+	var rule RelayRule
+	var matched bool
+	if link != nil {
+		rule, matched = matchRelayRule(s.cfg.RelayRules, info)
+		if matched && rule.Deny {
+			log.Println("Relay rule denies request from ", mac, " relayed from ", link)
+			return nil, nil
+		}
+		if matched && rule.Scope != nil {
+			scope = rule.Scope
+		}
+		if scope == nil {
+			log.Println("No scope for request from ", mac, " relayed from ", link)
+			return nil, nil
+		}
+	}
+	if scope != nil {
+		pool = scope.Pool
+		replyOptions = mergeOptions(replyOptions, scope.options())
+		// a client that moved subnet cannot keep its address
+		if hasLease && !scope.Subnet.Contains(lease.IP) {
+			hasLease = false
+		}
+	}
+	if matched {
+		replyOptions = mergeOptions(replyOptions, rule.options())
+	}
+
+	// With classes configured only their members, and clients reserved or
+	// registered in MPS, are answered; other hosts are left to the site
+	// DHCP server unless they are to be served without the suffix
+	res, reserved := s.cfg.Reservations.Lookup(req.CHAddr(), clientUUID(options))
+	domain, assigned := s.cfg.RPS.Lookup(clientUUID(options))
+	if len(s.cfg.Classes) > 0 {
+		class, member := classify(s.cfg.Classes, req, options)
+		switch {
+		case member && class.Deny && !reserved && !assigned:
+			log.Println("Client ", mac, " of class ", class.Name, " is denied")
+			return nil, nil
+		case member:
+			replyOptions = mergeOptions(replyOptions, class.options())
+		case !reserved && !assigned && !s.cfg.ServeUnclassified:
+			return nil, nil
+		case !reserved && !assigned:
+			replyOptions = withoutOption(replyOptions, OptionDomainName)
+		}
+	}
+	// Devices registered in MPS get the suffix of their domain, unless their
+	// reservation sets one
+	if assigned {
+		replyOptions = mergeOptions(replyOptions, domain.options())
+	}
+
+	// Clients keep the address they hold unless one is reserved for them
+	assignedIP := s.cfg.AssignIP
+	fixed := hasLease
+	if hasLease {
+		assignedIP = lease.IP
+	}
+	if reserved {
+		if res.IP != "" {
+			assignedIP = net.ParseIP(res.IP).To4()
+			fixed = true
+		}
+		replyOptions = mergeOptions(replyOptions, res.options())
+	}
+	if pool != nil && !fixed {
+		switch msgType {
+		case dhcpDiscover:
+			assignedIP, error = pool.Allocate(mac, s.addressInUse(mac, serverIP, replyOptions))
+			if errors.Is(error, ErrProbeLimit) {
+				// the client retries, and the next DISCOVER carries on
+				// past the addresses found in use
+				log.Println("No offer to ", mac, ": ", error)
+				return nil, nil
+			}
+			if error != nil {
+				return nil, error
+			}
+		case dhcpRequest:
+			assignedIP = pool.Offered(mac)
+			if assignedIP == nil {
+				candidate := net.IP(options[OptionRequestedIPAddress])
+				if len(candidate) != net.IPv4len {
+					candidate = req.CIAddr()
+				}
+				if pool.Available(candidate, mac, s.addressInUse(mac, serverIP, replyOptions)) {
+					assignedIP = candidate
+				}
+			}
+		}
+	}
+
+	// Clients sending option 81 are told the name to register under the
+	// suffix they are given
+	if fqdn, ok := fqdnReply(options, replyOptions); ok {
+		replyOptions = mergeOptions(replyOptions, []Option{fqdn})
+	}
+
+	switch msgType {
+	case dhcpDiscover:
+		return createReplyPacket(req, dhcpOffer, serverIP, assignedIP, replyOptions)
+	case dhcpRequest:
+		return s.handleDHCPRequest(req, options, serverIP, assignedIP, replyOptions, hasLease, pool)
+	case dhcpInform:
+		// Inform replies carry configuration only, no address or lease times
+		var informOptions []Option
+		for _, opt := range replyOptions {
+			switch opt.Code {
+			case OptionIPLeaseTime, OptionRenewalTime, OptionRebindingTime:
+				continue
+			}
+			informOptions = append(informOptions, opt)
+		}
+		return createReplyPacket(req, dhcpAck, serverIP, net.IPv4zero, informOptions)
+	case dhcpDecline:
+		// Only the address offered or leased to the client, by us, can be
+		// declined, or any host could take pool addresses out of use
+		declined := net.IP(options[OptionRequestedIPAddress])
+		leased := hasLease && lease.IP.Equal(declined)
+		offered := pool != nil && pool.Offered(mac).Equal(declined)
+		if !net.IP(options[OptionServerIdentifier]).Equal(serverIP) || len(declined) != net.IPv4len || !leased && !offered {
+			log.Println("Ignoring decline of ", declined, " by ", mac)
+			return nil, nil
+		}
+		log.Println("Client ", mac, " declined ", declined)
+		if pool != nil {
+			pool.Decline(declined)
+		}
+		if leased {
+			return nil, s.cfg.Leases.Delete(mac)
+		}
+	case dhcpRelease:
+		if hasLease && lease.IP.Equal(req.CIAddr()) {
+			log.Println("Client ", mac, " released ", lease.IP)
+			return nil, s.cfg.Leases.Delete(mac)
+		}
+	}
+	return nil, nil
+}
+
+// handleDHCPRequest answers a REQUEST in any of the client states described
+// in RFC 2131 section 4.3.2, recording the lease when it is acknowledged.
+func (s *Server) handleDHCPRequest(req Packet, options Options, serverIP net.IP, assignedIP net.IP, replyOptions []Option, hasLease bool, pool *Pool) (Packet, error) {
+	requested := net.IP(options[OptionRequestedIPAddress])
+	id, selecting := options[OptionServerIdentifier]
+	switch {
+	case selecting:
+		// A client that selected another server's offer names that server here
+		if !net.IP(id).Equal(serverIP) {
+			return nil, nil
+		}
+		if len(requested) != net.IPv4len || !requested.Equal(assignedIP) {
+			return createReplyPacket(req, dhcpNack, serverIP, net.IPv4zero, nil)
+		}
+	case len(requested) == net.IPv4len:
+		// INIT-REBOOT, stay silent about clients we have no record of
+		if !requested.Equal(assignedIP) {
+			if !hasLease {
+				return nil, nil
+			}
+			return createReplyPacket(req, dhcpNack, serverIP, net.IPv4zero, nil)
+		}
+	default:
+		// RENEWING or REBINDING, the address held is in ciaddr
+		if !req.CIAddr().Equal(assignedIP) {
+			return createReplyPacket(req, dhcpNack, serverIP, net.IPv4zero, nil)
+		}
+	}
+
+	lease := Lease{
+		MAC:        req.CHAddr().String(),
+		IP:         assignedIP,
+		Hostname:   clientHostname(options),
+		DomainName: string(optionValue(replyOptions, OptionDomainName)),
+		Expiry:     time.Now().Add(s.leaseTime(replyOptions)),
+	}
+	if error := s.cfg.Leases.Put(lease); error != nil {
+		return nil, error
+	}
+	if pool != nil {
+		pool.Commit(lease.MAC)
+	}
+	return createReplyPacket(req, dhcpAck, serverIP, assignedIP, replyOptions)
+}
+
+// leaseTime returns the lease time granted by replyOptions, which a scope
+// may have set apart from the server default.
+func (s *Server) leaseTime(replyOptions []Option) time.Duration {
+	if value := optionValue(replyOptions, OptionIPLeaseTime); len(value) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+	}
+	return s.cfg.LeaseTime
+}
+
+// addressInUse returns a check for addresses leased to or reserved for
+// clients other than mac, and for those of the server and the routers of
+// replyOptions, which a pool may well include.
+func (s *Server) addressInUse(mac string, serverIP net.IP, replyOptions []Option) func(net.IP) bool {
+	used := map[string]bool{serverIP.String(): true}
+	routers := optionValue(replyOptions, OptionRouter)
+	for i := 0; i+net.IPv4len <= len(routers); i += net.IPv4len {
+		used[net.IP(routers[i:i+net.IPv4len]).String()] = true
+	}
+	now := time.Now()
+	for _, lease := range s.cfg.Leases.List() {
+		if lease.MAC != mac && !lease.Expired(now) {
+			used[lease.IP.String()] = true
+		}
+	}
+	return func(ip net.IP) bool {
+		return used[ip.String()] || s.cfg.Reservations.ReservesIP(ip)
+	}
+}
+
+func (s *serverStatus) setListening(listening bool) {
+	s.mu.Lock()
+	s.status.Listening = listening
+	s.mu.Unlock()
+}
+
+func (s *serverStatus) setInterface(name string, broadcast string) {
+	s.mu.Lock()
+	s.status.Interface = name
+	s.status.Broadcast = broadcast
+	s.mu.Unlock()
+}
+
+func (s *serverStatus) setError(err error) {
+	s.mu.Lock()
+	s.status.LastError = err.Error()
+	s.mu.Unlock()
+}
+
+func (s *serverStatus) countRequest() {
+	s.mu.Lock()
+	s.status.Requests++
+	s.mu.Unlock()
+}
+
+func (s *serverStatus) countReply(reply Packet) {
+	options, err := reply.Options()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	switch options.MessageType() {
+	case dhcpOffer:
+		s.status.Offers++
+	case dhcpAck:
+		s.status.Acks++
+	}
+	s.mu.Unlock()
+}
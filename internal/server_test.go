@@ -0,0 +1,673 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *Server {
+	cfg := NewConfig("test.com")
+	cfg.AssignIP = net.ParseIP("10.20.30.131").To4()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.BindToDevice = false
+	s, err := NewServer(cfg)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestNewServerErrors(t *testing.T) {
+	_, err := NewServer(NewConfig(""))
+	assert.Error(t, err)
+
+	cfg := NewConfig("test.com")
+	cfg.AssignIP = net.ParseIP("fe80::1")
+	_, err = NewServer(cfg)
+	assert.Error(t, err)
+}
+
+func TestNewServerDefaults(t *testing.T) {
+	s, err := NewServer(Config{DNSSuffix: "test.com", AssignIP: net.IPv4(10, 0, 0, 5)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, ":67", s.Config().ListenAddr)
+	assert.NotNil(t, s.Config().Enumerator.Interfaces)
+	assert.False(t, s.Status().Started.IsZero())
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	cfg := NewConfig("site1.com")
+	site1, _ := NewServer(cfg)
+	cfg.DNSSuffix = "site2.com"
+	site2, _ := NewServer(cfg)
+
+	reply1, _ := site1.handleRequest(newTestSelectingRequest(serverIP, "169.254.214.131"), serverIP)
+	reply2, _ := site2.handleRequest(newTestSelectingRequest(serverIP, "169.254.214.131"), serverIP)
+
+	options1, _ := reply1.Options()
+	options2, _ := reply2.Options()
+	assert.Equal(t, []byte("site1.com"), options1[OptionDomainName])
+	assert.Equal(t, []byte("site2.com"), options2[OptionDomainName])
+	assert.Len(t, site1.Leases(), 1)
+	assert.Len(t, site2.Leases(), 1)
+}
+
+func TestServerRun(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Enumerator = newMockEnumerator()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.Run(ctx) }()
+	assert.Eventually(t, func() bool { return s.Status().Listening }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	assert.False(t, s.Status().Listening)
+	assert.Equal(t, "Ethernet", s.Status().Interface)
+	assert.Equal(t, "10.20.30.255", s.Status().Broadcast)
+}
+
+func TestServerBroadcastAddr(t *testing.T) {
+	s := newTestServer(t)
+	iface, _ := selectInterface(newTestEnumerator(""), "br0")
+
+	assert.Equal(t, "192.168.255.255", s.broadcastAddr(iface))
+
+	s.cfg.LimitedBroadcast = true
+	assert.Equal(t, "255.255.255.255", s.broadcastAddr(iface))
+}
+
+func TestServerReplyAddrRaw(t *testing.T) {
+	s := newTestServer(t)
+	ack, _ := createReplyPacket(newTestRequest(dhcpRequest), dhcpAck, net.IPv4(10, 20, 30, 34), net.IPv4(10, 20, 30, 131), nil)
+
+	addr, err := s.replyAddr(ack, "10.20.30.255")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.255:68", addr.String())
+
+	s.cfg.RawSocket = true
+	addr, err = s.replyAddr(ack, "10.20.30.255")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.131:68", addr.String())
+
+	ack.SetFlags([]byte{128, 0})
+	addr, err = s.replyAddr(ack, "10.20.30.255")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.255:68", addr.String())
+}
+
+func TestServerRunListenError(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Enumerator = newMockEnumerator()
+	s.cfg.ListenAddr = "256.0.0.1:0"
+
+	assert.Error(t, s.Run(context.Background()))
+}
+
+func TestServerCountsReplies(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	offer, _ := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	ack, _ := s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+	s.status.countRequest()
+	s.status.countReply(offer)
+	s.status.countReply(ack)
+
+	status := s.Status()
+	assert.Equal(t, uint64(1), status.Requests)
+	assert.Equal(t, uint64(1), status.Offers)
+	assert.Equal(t, uint64(1), status.Acks)
+}
+
+func TestServerReleaseForgetsLease(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+	assert.Len(t, s.Leases(), 1)
+
+	// a release must come from the address leased
+	release := newTestRequest(dhcpRelease)
+	s.handleRequest(release, serverIP)
+	assert.Len(t, s.Leases(), 1)
+
+	release.SetCIAddr(net.ParseIP("10.20.30.131"))
+	s.handleRequest(release, serverIP)
+	assert.Len(t, s.Leases(), 0)
+}
+
+func TestHandleRequestDiscover(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := newTestServer(t).handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, OpCode(2), reply.OpCode())
+	assert.Equal(t, []byte{byte(dhcpOffer)}, options[OptionDHCPMessageType])
+	assert.Equal(t, []byte(serverIP), options[OptionServerIdentifier])
+	assert.Equal(t, "10.20.30.131", reply.YIAddr().String())
+}
+
+func TestHandleRequestRequest(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := newTestServer(t).handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte{byte(dhcpAck)}, options[OptionDHCPMessageType])
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+}
+
+func TestHandleRequestOtherServer(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionServerIdentifier, []byte{10, 20, 30, 1})
+
+	reply, err := newTestServer(t).handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+}
+
+func TestHandleRequestInform(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := newTestServer(t).handleRequest(newTestRequest(dhcpInform), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, []byte{byte(dhcpAck)}, options[OptionDHCPMessageType])
+	assert.Equal(t, "0.0.0.0", reply.YIAddr().String())
+	assert.NotContains(t, options, OptionIPLeaseTime)
+}
+
+func TestHandleRequestIgnored(t *testing.T) {
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := newTestServer(t).handleRequest(newTestRequest(dhcpRelease), serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	reply, err = newTestServer(t).handleRequest(NewPacket(bootReply), serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	_, err = newTestServer(t).handleRequest(NewPacket(bootRequest), serverIP)
+	assert.Error(t, err)
+}
+
+// newTestSelectingRequest returns a REQUEST accepting the offer of ip made by
+// serverIP.
+func newTestSelectingRequest(serverIP net.IP, ip string) Packet {
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionServerIdentifier, serverIP.To4())
+	req.AddOption(OptionRequestedIPAddress, net.ParseIP(ip).To4())
+	return req
+}
+
+func newMockEnumerator() NetworkEnumerator {
+	parsedMac, _ := net.ParseMAC("DE:AD:BE:EF:FF:FF")
+	interfaces := []net.Interface{{Index: 1, MTU: 1500, Name: "Ethernet", HardwareAddr: parsedMac, Flags: net.FlagUp}}
+	return NetworkEnumerator{
+		Interfaces: func() ([]net.Interface, error) { return interfaces, nil },
+		Addrs:      func(*net.Interface) ([]net.Addr, error) { return []net.Addr{mockIPV6Addr{}, mockIPV4Addr{}}, nil },
+	}
+}
+
+func TestHandleRequestRecordsLease(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestSelectingRequest(serverIP, "10.20.30.131")
+	req.AddOption(OptionHostName, []byte("amt-host"))
+
+	reply, err := s.handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, reply)
+	lease, ok := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.True(t, ok)
+	assert.Equal(t, "10.20.30.131", lease.IP.String())
+	assert.Equal(t, "amt-host", lease.Hostname)
+	assert.Equal(t, "test.com", lease.DomainName)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), lease.Expiry, time.Minute)
+}
+
+func TestHandleRequestSelectingWrongAddress(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	reply, err := s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.99"), serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, dhcpNack, options.MessageType())
+	assert.Equal(t, "0.0.0.0", reply.YIAddr().String())
+	assert.Len(t, s.Leases(), 0)
+}
+
+func TestHandleRequestInitReboot(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionRequestedIPAddress, []byte{10, 20, 30, 99})
+
+	// no record of the client, so stay silent
+	reply, err := s.handleRequest(req, serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	// a client we leased another address to is told no
+	s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+	reply, err = s.handleRequest(req, serverIP)
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, dhcpNack, options.MessageType())
+}
+
+func TestHandleRequestRenew(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.cfg.Leases.Put(Lease{MAC: "00:11:22:33:44:55", IP: net.ParseIP("10.20.30.77").To4(), Expiry: time.Now().Add(time.Minute)})
+	req := newTestRequest(dhcpRequest)
+	req.SetCIAddr(net.ParseIP("10.20.30.77"))
+
+	reply, err := s.handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	options, _ := reply.Options()
+	assert.Equal(t, dhcpAck, options.MessageType())
+	assert.Equal(t, "10.20.30.77", reply.YIAddr().String())
+	assert.Equal(t, "10.20.30.77", reply.CIAddr().String())
+	lease, _ := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), lease.Expiry, time.Minute)
+
+	req.SetCIAddr(net.ParseIP("10.20.30.78"))
+	reply, _ = s.handleRequest(req, serverIP)
+	options, _ = reply.Options()
+	assert.Equal(t, dhcpNack, options.MessageType())
+}
+
+func TestHandleRequestExpiredLease(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.cfg.Leases.Put(Lease{MAC: "00:11:22:33:44:55", IP: net.ParseIP("10.20.30.77").To4(), Expiry: time.Now().Add(-time.Minute)})
+
+	reply, _ := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.Equal(t, "10.20.30.131", reply.YIAddr().String())
+}
+
+// newTestDecline returns a DECLINE of ip sent to the server serverID
+func newTestDecline(serverID net.IP, ip string) Packet {
+	decline := newTestRequest(dhcpDecline)
+	decline.AddOption(OptionServerIdentifier, serverID.To4())
+	decline.AddOption(OptionRequestedIPAddress, net.ParseIP(ip).To4())
+	return decline
+}
+
+func TestHandleRequestDecline(t *testing.T) {
+	s := newTestServer(t)
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.131"), serverIP)
+
+	reply, err := s.handleRequest(newTestDecline(serverIP, "10.20.30.131"), serverIP)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Len(t, s.Leases(), 0)
+}
+
+func TestHandleRequestDeclineIgnored(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.100-10.20.30.101", "")
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.100"), serverIP)
+	other := newTestDecline(serverIP, "10.20.30.101")
+	other.SetCHAddr(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x77})
+
+	for _, decline := range []Packet{
+		// meant for another server
+		newTestDecline(net.ParseIP("10.20.30.1"), "10.20.30.100"),
+		// not the address leased
+		newTestDecline(serverIP, "10.20.30.101"),
+		// by a client never offered it
+		other,
+		newTestRequest(dhcpDecline),
+	} {
+		reply, err := s.handleRequest(decline, serverIP)
+		assert.NoError(t, err)
+		assert.Nil(t, reply)
+	}
+
+	assert.Len(t, s.Leases(), 1)
+	assert.True(t, s.cfg.Pool.Available(net.ParseIP("10.20.30.101"), "00:11:22:33:44:77", notInUse))
+}
+
+func TestNewServerLeaseTimes(t *testing.T) {
+	cfg := NewConfig("test.com")
+	cfg.LeaseTime = time.Hour
+	cfg.RenewalTime = 0
+	cfg.RebindTime = 2 * time.Hour
+
+	s, err := NewServer(cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, s.Config().RenewalTime)
+	assert.Equal(t, 52*time.Minute+30*time.Second, s.Config().RebindTime)
+	assert.Equal(t, IntToByteArray(3600, 4), optionValue(s.options, OptionIPLeaseTime))
+}
+
+func TestHandleRequestPool(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.100-10.20.30.101", "")
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.cfg.Leases.Put(Lease{MAC: "00:11:22:33:44:66", IP: net.ParseIP("10.20.30.100").To4(), Expiry: time.Now().Add(time.Hour)})
+
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.101", offer.YIAddr().String())
+
+	ack, err := s.handleRequest(newTestSelectingRequest(serverIP, "10.20.30.101"), serverIP)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.101", ack.YIAddr().String())
+	assert.Nil(t, s.cfg.Pool.Offered("00:11:22:33:44:55"))
+
+	other := newTestRequest(dhcpDiscover)
+	other.SetCHAddr(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x77})
+	_, err = s.handleRequest(other, serverIP)
+	assert.Equal(t, ErrPoolExhausted, err)
+}
+
+func TestHandleRequestPoolProbeLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.100-10.20.30.102", "")
+	s.cfg.Pool.Prober = testProber{"10.20.30.100": true, "10.20.30.101": true}
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	// no offer yet, and no error either
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+
+	offer, err = s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.102", offer.YIAddr().String())
+}
+
+func TestHandleRequestPoolSkipsServerAndRouter(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.33-10.20.30.35", "")
+	s.options = mergeOptions(s.options, []Option{{Code: OptionRouter, Value: []byte{10, 20, 30, 33}}})
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.35", offer.YIAddr().String())
+	assert.False(t, s.cfg.Pool.Available(serverIP, "00:11:22:33:44:66", s.addressInUse("00:11:22:33:44:66", serverIP, s.options)))
+}
+
+func TestHandleRequestPoolInitReboot(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.100-10.20.30.101", "")
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionRequestedIPAddress, []byte{10, 20, 30, 101})
+
+	reply, err := s.handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.20.30.101", reply.YIAddr().String())
+}
+
+func TestHandleRequestPoolDecline(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.100-10.20.30.101", "")
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	s.handleRequest(newTestDecline(serverIP, "10.20.30.100"), serverIP)
+	offer, _ := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.Equal(t, "10.20.30.101", offer.YIAddr().String())
+}
+
+func TestHandleRequestPoolSkipsReserved(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Pool, _ = ParsePool("10.20.30.40-10.20.30.41", "")
+	s.cfg.Reservations, _ = LoadReservations(writeReservations(t, "reservations: [{mac: 00:11:22:33:44:66, ip: 10.20.30.40}]"))
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	offer, _ := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.Equal(t, "10.20.30.41", offer.YIAddr().String())
+}
+
+func newTestRelayedRequest(msgType MessageType) Packet {
+	req := newTestRequest(msgType)
+	req.SetGIAddr(net.ParseIP("10.50.0.1"))
+	return req
+}
+
+func TestHandleRequestRelayed(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	offer, err := s.handleRequest(newTestRelayedRequest(dhcpDiscover), serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.50.0.101", offer.YIAddr().String())
+	assert.Equal(t, "10.50.0.1", offer.GIAddr().String())
+	options, _ := offer.Options()
+	assert.Equal(t, []byte("branch1.example.com"), options[OptionDomainName])
+	assert.Equal(t, []byte{255, 255, 255, 0}, options[OptionSubnetMask])
+
+	req := newTestRelayedRequest(dhcpRequest)
+	req.AddOption(OptionServerIdentifier, serverIP)
+	req.AddOption(OptionRequestedIPAddress, []byte{10, 50, 0, 101})
+	ack, err := s.handleRequest(req, serverIP)
+	assert.NoError(t, err)
+	options, _ = ack.Options()
+	assert.Equal(t, dhcpAck, options.MessageType())
+	assert.Nil(t, s.cfg.Scopes[0].Pool.Offered("00:11:22:33:44:55"))
+	lease, _ := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.Equal(t, "10.50.0.101", lease.IP.String())
+	assert.Equal(t, "branch1.example.com", lease.DomainName)
+}
+
+func TestHandleRequestRelayedLinkSelection(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	req := newTestRelayedRequest(dhcpDiscover)
+	req.AddOption(OptionRelayAgentInformation, []byte{relayLinkSelection, 4, 10, 60, 0, 0})
+
+	offer, err := s.handleRequest(req, net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.60.1.10", offer.YIAddr().String())
+}
+
+func TestHandleRequestRelayedUnknownSubnet(t *testing.T) {
+	s := newTestServer(t)
+	req := newTestRequest(dhcpDiscover)
+	req.SetGIAddr(net.ParseIP("10.70.0.1"))
+
+	reply, err := s.handleRequest(req, net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+}
+
+func TestHandleRequestRelayedMovedClient(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	s.cfg.Leases.Put(Lease{MAC: "00:11:22:33:44:55", IP: net.ParseIP("10.20.30.77").To4(), Expiry: time.Now().Add(time.Hour)})
+
+	offer, _ := s.handleRequest(newTestRelayedRequest(dhcpDiscover), net.ParseIP("10.20.30.34").To4())
+
+	assert.Equal(t, "10.50.0.101", offer.YIAddr().String())
+}
+
+func TestHandleRequestLocalScope(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	serverIP := net.ParseIP("10.50.0.2").To4()
+
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.50.0.101", offer.YIAddr().String())
+	assert.Equal(t, "0.0.0.0", offer.GIAddr().String())
+	options, _ := offer.Options()
+	assert.Equal(t, []byte{10, 50, 0, 1}, options[OptionRouter])
+	assert.Equal(t, []byte("branch1.example.com"), options[OptionDomainName])
+
+	_, err = s.handleRequest(newTestSelectingRequest(serverIP, "10.50.0.101"), serverIP)
+	assert.NoError(t, err)
+	lease, _ := s.cfg.Leases.Get("00:11:22:33:44:55")
+	assert.WithinDuration(t, time.Now().Add(8*time.Hour), lease.Expiry, time.Minute)
+
+	// interfaces outside every scope keep the server defaults
+	s = newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	offer, _ = s.handleRequest(newTestRequest(dhcpDiscover), net.ParseIP("10.20.30.34").To4())
+	assert.Equal(t, "10.20.30.131", offer.YIAddr().String())
+	options, _ = offer.Options()
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+}
+
+func newTestScopes() []*Scope {
+	cfg, _ := parseConfigFile([]byte(testScopes))
+	return cfg.Scopes
+}
+
+func TestHandleRequestRelayRules(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	s.cfg.RelayRules = []RelayRule{
+		{CircuitID: []byte("p12"), DomainName: "floor3.example.com", Scope: s.cfg.Scopes[1]},
+		{RemoteID: []byte("guest"), Deny: true},
+	}
+	serverIP := net.ParseIP("10.20.30.34").To4()
+	info := []byte{relayCircuitID, 3, 'p', '1', '2', relayRemoteID, 2, 's', '3'}
+	req := newTestRelayedRequest(dhcpDiscover)
+	req.AddOption(OptionRelayAgentInformation, info)
+
+	offer, err := s.handleRequest(req, serverIP)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.60.1.10", offer.YIAddr().String())
+	options, _ := offer.Options()
+	assert.Equal(t, []byte("floor3.example.com"), options[OptionDomainName])
+	assert.Equal(t, []byte{255, 255, 0, 0}, options[OptionSubnetMask])
+	assert.Equal(t, info, options[OptionRelayAgentInformation])
+
+	denied := newTestRelayedRequest(dhcpDiscover)
+	denied.AddOption(OptionRelayAgentInformation, []byte{relayRemoteID, 5, 'g', 'u', 'e', 's', 't'})
+	reply, err := s.handleRequest(denied, serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	// other relayed requests still use the scope of their subnet
+	offer, _ = s.handleRequest(newTestRelayedRequest(dhcpDiscover), serverIP)
+	assert.Equal(t, "10.50.0.101", offer.YIAddr().String())
+}
+
+func TestHandleRequestRelayAgentInfoInvalid(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Scopes = newTestScopes()
+	req := newTestRelayedRequest(dhcpDiscover)
+	req.AddOption(OptionRelayAgentInformation, []byte{relayCircuitID, 3, 'p'})
+
+	_, err := s.handleRequest(req, net.ParseIP("10.20.30.34").To4())
+
+	assert.ErrorIs(t, err, ErrOptionTruncated)
+}
+
+func TestHandleRequestClasses(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Classes = newTestClasses()
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	// ordinary hosts are left to the site DHCP server
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionVendorClassIdentifier, []byte("iAMT"))
+	offer, _ = s.handleRequest(req, serverIP)
+	options, _ := offer.Options()
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+
+	req = newTestRequest(dhcpDiscover)
+	req.AddOption(OptionClientMachineID, newTestMachineID())
+	offer, _ = s.handleRequest(req, serverIP)
+	options, _ = offer.Options()
+	assert.Equal(t, []byte("lab.example.com"), options[OptionDomainName])
+
+	req = newTestRequest(dhcpDiscover)
+	req.AddOption(OptionVendorClassIdentifier, []byte("Hewlett-Packard"))
+	offer, err = s.handleRequest(req, serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+}
+
+func TestHandleRequestServeUnclassified(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Classes = newTestClasses()
+	s.cfg.ServeUnclassified = true
+	serverIP := net.ParseIP("10.20.30.34").To4()
+
+	// ordinary hosts are served, without the suffix
+	offer, err := s.handleRequest(newTestRequest(dhcpDiscover), serverIP)
+	assert.NoError(t, err)
+	options, _ := offer.Options()
+	assert.Nil(t, options[OptionDomainName])
+	assert.NotNil(t, options[OptionSubnetMask])
+
+	// members still get it, and denied classes nothing
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionVendorClassIdentifier, []byte("iAMT"))
+	offer, _ = s.handleRequest(req, serverIP)
+	options, _ = offer.Options()
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+
+	req = newTestRequest(dhcpDiscover)
+	req.AddOption(OptionVendorClassIdentifier, []byte("Hewlett-Packard"))
+	offer, err = s.handleRequest(req, serverIP)
+	assert.NoError(t, err)
+	assert.Nil(t, offer)
+}
+
+func TestHandleRequestClassesReserved(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.Classes = newTestClasses()
+	s.cfg.Reservations, _ = LoadReservations(writeReservations(t, "reservations:\n  - mac: 00:11:22:33:44:55\n"))
+	req := newTestRequest(dhcpDiscover)
+	req.AddOption(OptionVendorClassIdentifier, []byte("Hewlett-Packard"))
+
+	offer, err := s.handleRequest(req, net.ParseIP("10.20.30.34").To4())
+
+	assert.NoError(t, err)
+	options, _ := offer.Options()
+	assert.Equal(t, []byte("test.com"), options[OptionDomainName])
+}
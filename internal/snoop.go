@@ -0,0 +1,168 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SnoopedClient is what a Snooper learnt about one client from the DHCP
+// traffic on the segment
+type SnoopedClient struct {
+	MAC         string    `json:"mac"`
+	UUID        string    `json:"uuid,omitempty"`
+	XID         string    `json:"xid"`
+	VendorClass string    `json:"vendorClass,omitempty"`
+	Class       string    `json:"class,omitempty"`
+	IP          net.IP    `json:"ip,omitempty"`
+	ServerID    net.IP    `json:"serverId,omitempty"`
+	Expiry      time.Time `json:"expiry"`
+	Injected    bool      `json:"injected"`
+	Seen        time.Time `json:"seen"`
+}
+
+const (
+	// snoopIdleTime is how long a client is remembered once its lease, if
+	// any, has expired and it was last seen
+	snoopIdleTime = 10 * time.Minute
+	// maxSnoopedClients bounds the clients remembered, anyone on the segment
+	// can make up chaddrs
+	maxSnoopedClients = 4096
+)
+
+// Snooper follows the requests of clients and the ACKs servers send them,
+// remembering per client whether the DNS suffix was injected for its current
+// lease.  Clients are forgotten once idle, and the least recently seen when
+// too many are known.
+type Snooper struct {
+	mu      sync.Mutex
+	classes []ClientClass
+	clients map[string]*SnoopedClient
+	limit   int
+	swept   time.Time
+}
+
+// NewSnooper returns a Snooper sorting the clients it sees into classes
+func NewSnooper(classes []ClientClass) *Snooper {
+	return &Snooper{
+		classes: classes,
+		clients: make(map[string]*SnoopedClient),
+		limit:   maxSnoopedClients,
+		swept:   time.Now(),
+	}
+}
+
+// Observe records what pkt reveals about its client and returns what is
+// known about the client so far.  An ACK for a different address, or one
+// received after the previous lease expired, starts a new lease.
+func (s *Snooper) Observe(pkt Packet) (SnoopedClient, error) {
+	options, err := pkt.Options()
+	if err != nil {
+		return SnoopedClient{}, err
+	}
+	now := time.Now()
+	mac := pkt.CHAddr().String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) > snoopIdleTime {
+		s.evict(now)
+	}
+	client, ok := s.clients[mac]
+	if !ok {
+		if len(s.clients) >= s.limit {
+			s.evict(now)
+		}
+		if len(s.clients) >= s.limit {
+			s.evictOldest()
+		}
+		client = &SnoopedClient{MAC: mac}
+		s.clients[mac] = client
+	}
+	client.XID = hex.EncodeToString(pkt.XId())
+	client.Seen = now
+
+	switch pkt.OpCode() {
+	case bootRequest:
+		if vendorClass, ok := options[OptionVendorClassIdentifier]; ok {
+			client.VendorClass = string(vendorClass)
+		}
+		if uuid := clientUUID(options); uuid != "" {
+			client.UUID = uuid
+		}
+		class, _ := classify(s.classes, pkt, options)
+		client.Class = class.Name
+	case bootReply:
+		assignedIP := pkt.YIAddr()
+		if options.MessageType() != dhcpAck || assignedIP.Equal(net.IPv4zero) {
+			break
+		}
+		if !assignedIP.Equal(client.IP) || now.After(client.Expiry) {
+			client.Injected = false
+		}
+		client.IP = assignedIP
+		client.ServerID = net.IP(options[OptionServerIdentifier])
+		if leaseTime := options[OptionIPLeaseTime]; len(leaseTime) == 4 {
+			client.Expiry = now.Add(time.Duration(binary.BigEndian.Uint32(leaseTime)) * time.Second)
+		}
+	}
+	return *client, nil
+}
+
+// evict forgets the clients idle for snoopIdleTime.  s.mu must be held.
+func (s *Snooper) evict(now time.Time) {
+	for mac, client := range s.clients {
+		if now.After(client.Expiry) && now.Sub(client.Seen) > snoopIdleTime {
+			delete(s.clients, mac)
+		}
+	}
+	s.swept = now
+}
+
+// evictOldest forgets the least recently seen client.  s.mu must be held.
+func (s *Snooper) evictOldest() {
+	var oldest *SnoopedClient
+	for _, client := range s.clients {
+		if oldest == nil || client.Seen.Before(oldest.Seen) {
+			oldest = client
+		}
+	}
+	if oldest != nil {
+		delete(s.clients, oldest.MAC)
+	}
+}
+
+// Inject marks the current lease of the client mac as sent the DNS suffix.
+// It returns false when it already was, so each lease is injected once.
+func (s *Snooper) Inject(mac string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[mac]
+	if !ok || client.Injected {
+		return false
+	}
+	client.Injected = true
+	return true
+}
+
+// Clients returns the clients seen, ordered by MAC address
+func (s *Snooper) Clients() []SnoopedClient {
+	clients := []SnoopedClient{}
+	if s == nil {
+		return clients
+	}
+	s.mu.Lock()
+	for _, client := range s.clients {
+		clients = append(clients, *client)
+	}
+	s.mu.Unlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].MAC < clients[j].MAC })
+	return clients
+}
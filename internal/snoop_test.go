@@ -0,0 +1,134 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAMTRequest returns a REQUEST carrying the AMT vendor class
+func newTestAMTRequest() Packet {
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionVendorClassIdentifier, []byte("iAMT"))
+	return req
+}
+
+func TestSnooperObserveRequest(t *testing.T) {
+	s := NewSnooper(DefaultClientClasses())
+
+	client, err := s.Observe(newTestAMTRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:55", client.MAC)
+	assert.Equal(t, "01020304", client.XID)
+	assert.Equal(t, "iAMT", client.VendorClass)
+	assert.Equal(t, "amt", client.Class)
+
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionVendorClassIdentifier, []byte("MSFT 5.0"))
+	client, _ = s.Observe(req)
+	assert.Empty(t, client.Class)
+}
+
+func TestSnooperObserveAck(t *testing.T) {
+	s := NewSnooper(DefaultClientClasses())
+
+	client, err := s.Observe(newTestSiteAck())
+
+	assert.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 20, 30, 77).To4(), client.IP)
+	assert.Equal(t, siteServerIP, client.ServerID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), client.Expiry, time.Minute)
+	assert.False(t, client.Injected)
+}
+
+func TestSnooperInjectOncePerLease(t *testing.T) {
+	s := NewSnooper(DefaultClientClasses())
+	assert.False(t, s.Inject("00:11:22:33:44:55"))
+
+	s.Observe(newTestSiteAck())
+	assert.True(t, s.Inject("00:11:22:33:44:55"))
+	assert.False(t, s.Inject("00:11:22:33:44:55"))
+
+	// renewing the same lease does not inject again
+	client, _ := s.Observe(newTestSiteAck())
+	assert.True(t, client.Injected)
+	assert.False(t, s.Inject("00:11:22:33:44:55"))
+
+	// a new address is a new lease
+	ack, _ := createReplyPacket(newTestRequest(dhcpRequest), dhcpAck, siteServerIP, net.IPv4(10, 20, 30, 78), nil)
+	client, _ = s.Observe(ack)
+	assert.False(t, client.Injected)
+	assert.True(t, s.Inject("00:11:22:33:44:55"))
+}
+
+func TestSnooperClients(t *testing.T) {
+	var none *Snooper
+	assert.Equal(t, []SnoopedClient{}, none.Clients())
+
+	s := NewSnooper(DefaultClientClasses())
+	other := newTestRequest(dhcpDiscover)
+	mac, _ := net.ParseMAC("00:00:00:00:00:01")
+	other.SetCHAddr(mac)
+	s.Observe(newTestAMTRequest())
+	s.Observe(other)
+
+	clients := s.Clients()
+	assert.Len(t, clients, 2)
+	assert.Equal(t, "00:00:00:00:00:01", clients[0].MAC)
+	assert.Equal(t, "00:11:22:33:44:55", clients[1].MAC)
+}
+
+// newTestSnoopedRequest returns a DISCOVER from the client with the last
+// MAC address byte b
+func newTestSnoopedRequest(b byte) Packet {
+	req := newTestRequest(dhcpDiscover)
+	req.SetCHAddr(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, b})
+	return req
+}
+
+func TestSnooperEvictsIdleClients(t *testing.T) {
+	s := NewSnooper(DefaultClientClasses())
+	s.Observe(newTestSnoopedRequest(1))
+	s.Observe(newTestSiteAck())
+	for _, client := range s.clients {
+		client.Seen = time.Now().Add(-2 * snoopIdleTime)
+	}
+	s.swept = time.Now().Add(-2 * snoopIdleTime)
+
+	s.Observe(newTestSnoopedRequest(2))
+
+	// the client holding a lease is kept until it expires
+	var macs []string
+	for _, client := range s.Clients() {
+		macs = append(macs, client.MAC)
+	}
+	assert.Equal(t, []string{"00:11:22:33:44:02", "00:11:22:33:44:55"}, macs)
+}
+
+func TestSnooperLimit(t *testing.T) {
+	s := NewSnooper(DefaultClientClasses())
+	s.limit = 2
+	s.Observe(newTestSnoopedRequest(1))
+	s.clients["00:11:22:33:44:01"].Seen = time.Now().Add(-time.Minute)
+	s.Observe(newTestSnoopedRequest(2))
+
+	s.Observe(newTestSnoopedRequest(3))
+
+	clients := s.Clients()
+	assert.Len(t, clients, 2)
+	assert.Equal(t, "00:11:22:33:44:02", clients[0].MAC)
+	assert.Equal(t, "00:11:22:33:44:03", clients[1].MAC)
+}
+
+func TestSnooperObserveMalformed(t *testing.T) {
+	req := newTestRequest(dhcpRequest)
+	req.AddOption(OptionVendorClassIdentifier, nil)
+	_, err := NewSnooper(DefaultClientClasses()).Observe(Packet(req[:len(req)-1]))
+	assert.Error(t, err)
+}
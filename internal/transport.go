@@ -0,0 +1,148 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Peer is the far end of a datagram and the interface it crossed
+type Peer struct {
+	Interface string
+	Addr      *net.UDPAddr
+}
+
+// Transport carries DHCP messages for a Server.  UDP sockets, raw sockets
+// and the in-memory Hub implement it.
+type Transport interface {
+	// ReadFrom blocks until a datagram arrives and copies it into b
+	ReadFrom(b []byte) (int, Peer, error)
+	// WriteTo sends b to peer.Addr
+	WriteTo(b []byte, peer Peer) (int, error)
+	// Close unblocks pending reads and releases the transport
+	Close() error
+}
+
+// ListenFunc opens a Transport bound to addr on iface
+type ListenFunc func(ctx context.Context, iface NetworkInterface, addr string) (Transport, error)
+
+// packetTransport adapts a net.PacketConn opened on a single interface
+type packetTransport struct {
+	conn  net.PacketConn
+	iface string
+}
+
+// NewPacketTransport returns a Transport reading and writing through conn,
+// reporting iface as the interface of every peer.
+func NewPacketTransport(conn net.PacketConn, iface string) Transport {
+	return &packetTransport{conn: conn, iface: iface}
+}
+
+func (t *packetTransport) ReadFrom(b []byte) (int, Peer, error) {
+	n, from, err := t.conn.ReadFrom(b)
+	if err != nil {
+		return n, Peer{}, err
+	}
+	addr, _ := from.(*net.UDPAddr)
+	return n, Peer{Interface: t.iface, Addr: addr}, nil
+}
+
+func (t *packetTransport) WriteTo(b []byte, peer Peer) (int, error) {
+	if peer.Addr == nil {
+		return 0, errors.New("peer has no address")
+	}
+	return t.conn.WriteTo(b, peer.Addr)
+}
+
+func (t *packetTransport) Close() error { return t.conn.Close() }
+
+// ListenUDP returns a ListenFunc for UDP sockets, optionally restricted to
+// the interface with SO_BINDTODEVICE.
+func ListenUDP(bind bool) ListenFunc {
+	return listenUDP(bind, false)
+}
+
+// listenUDP is ListenUDP, setting SO_REUSEADDR when shared
+func listenUDP(bind bool, shared bool) ListenFunc {
+	return func(ctx context.Context, iface NetworkInterface, addr string) (Transport, error) {
+		var controls []func(network, address string, c syscall.RawConn) error
+		if bind {
+			controls = append(controls, bindToDevice(iface.Name))
+		}
+		if shared {
+			controls = append(controls, reuseAddr)
+		}
+		listener := net.ListenConfig{Control: socketControl(controls...)}
+		conn, err := listener.ListenPacket(ctx, "udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		return NewPacketTransport(conn, iface.Name), nil
+	}
+}
+
+// socketControl chains socket control functions, skipping nil ones
+func socketControl(controls ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range controls {
+			if control == nil {
+				continue
+			}
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// rawTransport is a raw socket transport holding a UDP socket on its port
+// as well.  Without it the kernel answers datagrams unicast to the port,
+// such as RENEWs, with ICMP port unreachable.  The raw socket reads those
+// datagrams too, so what the UDP socket reads is dropped.
+type rawTransport struct {
+	Transport
+	udp net.PacketConn
+}
+
+func (t *rawTransport) Close() error {
+	t.udp.Close()
+	return t.Transport.Close()
+}
+
+// discard reads and drops datagrams until conn is closed
+func discard(conn net.PacketConn) {
+	buffer := make([]byte, 1)
+	for {
+		if _, _, err := conn.ReadFrom(buffer); err != nil {
+			return
+		}
+	}
+}
+
+// ListenRawTransport is the ListenFunc for raw sockets, see ListenRaw.  The
+// port is bound with SO_REUSEADDR, so transports opened on it to send, like
+// the one of Server.SendAck, do not conflict.
+func ListenRawTransport(ctx context.Context, iface NetworkInterface, addr string) (Transport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ListenRaw(iface, udpAddr.Port)
+	if err != nil {
+		return nil, err
+	}
+	listener := net.ListenConfig{Control: socketControl(reuseAddr, bindToDevice(iface.Name))}
+	udp, err := listener.ListenPacket(ctx, "udp4", addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go discard(udp)
+	return &rawTransport{Transport: NewPacketTransport(conn, iface.Name), udp: udp}, nil
+}
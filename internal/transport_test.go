@@ -0,0 +1,73 @@
+/*********************************************************************
+ * Copyright (c) Intel Corporation 2021
+ * SPDX-License-Identifier: Apache-2.0
+ **********************************************************************/
+package rpe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUDP(t *testing.T) {
+	iface := NetworkInterface{Name: "lo"}
+	server, err := ListenUDP(false)(context.Background(), iface, "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer server.Close()
+	serverAddr := server.(*packetTransport).conn.LocalAddr().(*net.UDPAddr)
+
+	client, err := net.DialUDP("udp4", nil, serverAddr)
+	assert.NoError(t, err)
+	defer client.Close()
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = client.Write([]byte("discover"))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 1500)
+	n, peer, err := server.ReadFrom(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "discover", string(buffer[:n]))
+	assert.Equal(t, "lo", peer.Interface)
+	assert.Equal(t, client.LocalAddr().String(), peer.Addr.String())
+
+	_, err = server.WriteTo([]byte("offer"), peer)
+	assert.NoError(t, err)
+	n, err = client.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "offer", string(buffer[:n]))
+}
+
+func TestListenUDPErrors(t *testing.T) {
+	_, err := ListenUDP(false)(context.Background(), NetworkInterface{}, "256.0.0.1:0")
+	assert.Error(t, err)
+
+	conn, err := ListenUDP(false)(context.Background(), NetworkInterface{}, "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.WriteTo([]byte("offer"), Peer{})
+	assert.Error(t, err)
+}
+
+func TestListenUDPShared(t *testing.T) {
+	conn, err := listenUDP(false, true)(context.Background(), NetworkInterface{}, "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	addr := conn.(*packetTransport).conn.LocalAddr().String()
+
+	// a DHCP client holding the port binds it shared as well
+	other, err := listenUDP(false, true)(context.Background(), NetworkInterface{}, addr)
+	if assert.NoError(t, err) {
+		other.Close()
+	}
+	_, err = ListenUDP(false)(context.Background(), NetworkInterface{}, addr)
+	assert.Error(t, err)
+}
+
+func TestListenRawTransportErrors(t *testing.T) {
+	_, err := ListenRawTransport(context.Background(), NetworkInterface{}, "256.0.0.1:0")
+	assert.Error(t, err)
+}